@@ -0,0 +1,260 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin implements an HTTP API to inspect and operate the
+// LeaderElectors of a process:
+//
+//	GET  /                 the status of all the electors
+//	GET  /{name}           the status of an elector
+//	POST /{name}/resign    resign the lease
+//	POST /{name}/pause     pause campaigning
+//	POST /{name}/resume    resume campaigning
+//	POST /{name}/transfer  transfer the lease to the "identity" form value
+//
+// The electors are registered under the name of their lease, or the
+// description of their lock if unnamed. The POST endpoints answer with the
+// new status of the elector, and require the requests to be authorized (see
+// Config):
+//
+//	h := admin.New(admin.Config{Token: os.Getenv("ADMIN_TOKEN")})
+//	if err := h.Register(elector); err != nil {
+//		return err
+//	}
+//	mux.Handle("/leaderelection/", http.StripPrefix("/leaderelection", h))
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	le "go.linka.cloud/leaderelection"
+)
+
+var _ http.Handler = (*Handler)(nil)
+
+// Config configures the authorization of the POST requests. They are all
+// rejected if neither Token nor Authorize is set.
+type Config struct {
+	// Token is the bearer token the requests must carry in their
+	// Authorization header.
+	Token string
+	// Authorize, if set, is called after the Token check, and rejects the
+	// request if it returns an error.
+	Authorize func(r *http.Request) error
+}
+
+// Status is the JSON representation of a le.Status.
+type Status struct {
+	Name             string     `json:"name"`
+	Identity         string     `json:"identity"`
+	Lock             string     `json:"lock"`
+	Leader           string     `json:"leader,omitempty"`
+	Leading          bool       `json:"leading"`
+	Paused           bool       `json:"paused"`
+	Record           *le.Record `json:"record,omitempty"`
+	ObservedTime     *time.Time `json:"observedTime,omitempty"`
+	ExpiresInSeconds float64    `json:"expiresInSeconds"`
+	LastError        string     `json:"lastError,omitempty"`
+}
+
+func newStatus(s le.Status) Status {
+	st := Status{
+		Name:             s.Name,
+		Identity:         s.Identity,
+		Lock:             s.Lock,
+		Leader:           s.Leader,
+		Leading:          s.Leading,
+		Paused:           s.Paused,
+		ExpiresInSeconds: s.ExpiresIn.Seconds(),
+	}
+	if !s.ObservedTime.IsZero() {
+		st.Record, st.ObservedTime = &s.Record, &s.ObservedTime
+	}
+	if s.LastError != nil {
+		st.LastError = s.LastError.Error()
+	}
+	return st
+}
+
+// Handler is the http.Handler serving the API.
+type Handler struct {
+	config Config
+
+	mu       sync.RWMutex
+	electors map[string]*le.LeaderElector
+}
+
+// New creates a Handler without electors.
+func New(c Config) *Handler {
+	return &Handler{config: c, electors: make(map[string]*le.LeaderElector)}
+}
+
+// name returns the name the elector e is registered under.
+func name(e *le.LeaderElector) string {
+	s := e.Status()
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Lock
+}
+
+// Register adds the elector e to the API. It fails if an elector is already
+// registered under the same name.
+func (h *Handler) Register(e *le.LeaderElector) error {
+	n := name(e)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.electors[n]; ok {
+		return fmt.Errorf("elector %s already registered", n)
+	}
+	h.electors[n] = e
+	return nil
+}
+
+// Unregister removes the elector e from the API.
+func (h *Handler) Unregister(e *le.LeaderElector) {
+	n := name(e)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.electors[n] == e {
+		delete(h.electors, n)
+	}
+}
+
+func (h *Handler) elector(name string) (*le.LeaderElector, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	e, ok := h.electors[name]
+	return e, ok
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if path == "" {
+			h.list(w)
+			return
+		}
+		e, ok := h.elector(path)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("elector %s not found", path))
+			return
+		}
+		write(w, http.StatusOK, newStatus(e.Status()))
+	case http.MethodPost:
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no action in %s", path))
+			return
+		}
+		if err := h.authorize(r); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		e, ok := h.elector(path[:i])
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("elector %s not found", path[:i]))
+			return
+		}
+		h.act(w, r, e, path[i+1:])
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// list writes the status of all the electors, sorted by name.
+func (h *Handler) list(w http.ResponseWriter) {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.electors))
+	for n := range h.electors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	out := make([]Status, 0, len(names))
+	for _, n := range names {
+		out = append(out, newStatus(h.electors[n].Status()))
+	}
+	h.mu.RUnlock()
+	write(w, http.StatusOK, out)
+}
+
+// act performs the action on the elector e and writes its new status.
+func (h *Handler) act(w http.ResponseWriter, r *http.Request, e *le.LeaderElector, action string) {
+	var err error
+	switch action {
+	case "resign":
+		err = e.Resign(r.Context())
+	case "pause":
+		e.Pause()
+	case "resume":
+		e.Resume()
+	case "transfer":
+		id := r.FormValue("identity")
+		if id == "" {
+			writeError(w, http.StatusBadRequest, errors.New("missing identity"))
+			return
+		}
+		err = e.Transfer(r.Context(), id)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %s", action))
+		return
+	}
+	switch {
+	case errors.Is(err, le.ErrNotLeader):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		write(w, http.StatusOK, newStatus(e.Status()))
+	}
+}
+
+// authorize checks the request against the Config.
+func (h *Handler) authorize(r *http.Request) error {
+	if h.config.Token == "" && h.config.Authorize == nil {
+		return errors.New("admin actions are disabled")
+	}
+	if h.config.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.config.Token)) != 1 {
+			return errors.New("invalid bearer token")
+		}
+	}
+	if h.config.Authorize != nil {
+		return h.config.Authorize(r)
+	}
+	return nil
+}
+
+func write(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	write(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
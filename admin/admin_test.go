@@ -0,0 +1,179 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/memory"
+)
+
+const retryPeriod = 2 * time.Second
+
+type elector struct {
+	*le.LeaderElector
+	leading atomic.Bool
+}
+
+func run(t *testing.T, ctx context.Context, s *memory.Store, clk *clocktesting.FakeClock, name, id string) *elector {
+	e := &elector{}
+	var err error
+	e.LeaderElector, err = le.New(le.Config{
+		Lock:          memory.New(s, name, id),
+		Name:          name,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   retryPeriod,
+		Clock:         clk,
+		Callbacks: le.Callbacks{
+			OnStartedLeading: func(context.Context) {
+				e.leading.Store(true)
+			},
+			OnStoppedLeading: func() {
+				e.leading.Store(false)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go e.Campaign(ctx, le.CampaignConfig{})
+	return e
+}
+
+// stepUntil steps the fake clock until cond returns true, failing after max.
+func stepUntil(t *testing.T, clk *clocktesting.FakeClock, max time.Duration, cond func() bool) {
+	t.Helper()
+	start := clk.Now()
+	for !cond() {
+		if clk.Since(start) > max {
+			t.Fatalf("condition not met after %v", max)
+		}
+		clk.Step(retryPeriod / 4)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func do(t *testing.T, h http.Handler, method, path, token string, form url.Values) (int, []byte) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, w.Body.Bytes()
+}
+
+func TestAdmin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := clocktesting.NewFakeClock(time.Now())
+	a := run(t, ctx, s, clk, "test", "a")
+	stepUntil(t, clk, retryPeriod, a.leading.Load)
+	b := run(t, ctx, s, clk, "test", "b")
+	c := run(t, ctx, s, clk, "other", "c")
+	stepUntil(t, clk, retryPeriod, c.leading.Load)
+
+	h := New(Config{Token: "secret"})
+	for _, e := range []*elector{a, c} {
+		if err := h.Register(e.LeaderElector); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Register(b.LeaderElector); err == nil {
+		t.Error("registered two electors with the same name")
+	}
+
+	code, body := do(t, h, http.MethodGet, "/", "", nil)
+	var list []Status
+	if err := json.Unmarshal(body, &list); code != http.StatusOK || err != nil {
+		t.Fatalf("got list %d %s: %v", code, body, err)
+	}
+	if len(list) != 2 || list[0].Name != "other" || list[1].Name != "test" {
+		t.Fatalf("got list %s", body)
+	}
+	if st := list[1]; st.Identity != "a" || st.Leader != "a" || !st.Leading || st.Lock != "memory/test" ||
+		st.Record == nil || st.Record.HolderIdentity != "a" || st.ObservedTime == nil || st.ExpiresInSeconds <= 0 || st.LastError != "" {
+		t.Errorf("got status %+v", st)
+	}
+	if code, _ := do(t, h, http.MethodGet, "/unknown", "", nil); code != http.StatusNotFound {
+		t.Errorf("got unknown elector status %d, want 404", code)
+	}
+
+	// the actions are authenticated
+	for _, token := range []string{"", "wrong"} {
+		if code, _ := do(t, h, http.MethodPost, "/test/resign", token, nil); code != http.StatusUnauthorized {
+			t.Errorf("got resign with token %q status %d, want 401", token, code)
+		}
+	}
+	if !a.leading.Load() {
+		t.Fatal("a stopped leading after unauthorized requests")
+	}
+	if code, _ := do(t, New(Config{}), http.MethodPost, "/test/resign", "secret", nil); code != http.StatusUnauthorized {
+		t.Errorf("got resign without authorization configured status %d, want 401", code)
+	}
+
+	// transfer to b
+	if code, _ := do(t, h, http.MethodPost, "/test/transfer", "secret", nil); code != http.StatusBadRequest {
+		t.Errorf("got transfer without identity status %d, want 400", code)
+	}
+	if code, body := do(t, h, http.MethodPost, "/test/transfer", "secret", url.Values{"identity": {"b"}}); code != http.StatusOK {
+		t.Fatalf("got transfer status %d: %s", code, body)
+	}
+	stepUntil(t, clk, 2*retryPeriod, b.leading.Load)
+	if code, _ := do(t, h, http.MethodPost, "/test/resign", "secret", nil); code != http.StatusConflict {
+		t.Errorf("got follower resign status %d, want 409", code)
+	}
+
+	// c pauses campaigning, then resigns: it does not acquire the lease again
+	code, body = do(t, h, http.MethodPost, "/other/pause", "secret", nil)
+	var st Status
+	if err := json.Unmarshal(body, &st); code != http.StatusOK || err != nil || !st.Paused {
+		t.Fatalf("got pause %d %s: %v", code, body, err)
+	}
+	if code, body := do(t, h, http.MethodPost, "/other/resign", "secret", nil); code != http.StatusOK {
+		t.Fatalf("got resign status %d: %s", code, body)
+	}
+	for i := 0; i < 10; i++ {
+		clk.Step(retryPeriod)
+		time.Sleep(time.Millisecond)
+	}
+	if c.leading.Load() || c.Status().Leading {
+		t.Fatal("c acquired the lease while paused")
+	}
+	if code, _ := do(t, h, http.MethodPost, "/other/resume", "secret", nil); code != http.StatusOK {
+		t.Errorf("got resume status %d", code)
+	}
+	stepUntil(t, clk, 2*retryPeriod, c.leading.Load)
+
+	h.Unregister(c.LeaderElector)
+	if code, _ := do(t, h, http.MethodGet, "/other", "", nil); code != http.StatusNotFound {
+		t.Errorf("got unregistered elector status %d, want 404", code)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// StopReason is the reason a leadership term ended.
+type StopReason int
+
+const (
+	// StopContext means that the run context was done.
+	StopContext StopReason = iota
+	// StopRenewDeadline means that the lease could not be renewed before
+	// the RenewDeadline.
+	StopRenewDeadline
+	// StopConflict means that the record was taken over by another term
+	// while renewing the lease.
+	StopConflict
+	// StopResign means that the lease was released by Resign.
+	StopResign
+	// StopTransfer means that the lease was transferred, either by Transfer
+	// or to a challenger with a higher priority.
+	StopTransfer
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopContext:
+		return "context done"
+	case StopRenewDeadline:
+		return "renew deadline exceeded"
+	case StopConflict:
+		return "conflict"
+	case StopResign:
+		return "resigned"
+	case StopTransfer:
+		return "transferred"
+	default:
+		return fmt.Sprintf("StopReason(%d)", int(r))
+	}
+}
+
+// CampaignConfig configures LeaderElector.Campaign.
+type CampaignConfig struct {
+	// Backoff is the delay before campaigning again after a leadership term
+	// ended, jittered by JitterFactor. It defaults to RetryPeriod.
+	Backoff time.Duration
+	// MaxTerms is the number of leadership terms after which Campaign returns.
+	// Zero means no limit.
+	MaxTerms int
+	// OnStoppedLeading, if set, is called after the Callbacks.OnStoppedLeading
+	// of each term, with the number of terms so far and the reason the term ended.
+	OnStoppedLeading func(term int, reason StopReason)
+}
+
+// Campaign runs the leader election loop like Run, but campaigns again for the
+// lease after each leadership term, until ctx is done or the CampaignConfig.MaxTerms
+// is reached. The state of the elector is reset between terms, except for the
+// last observed record.
+func (le *LeaderElector) Campaign(ctx context.Context, c CampaignConfig) {
+	if c.Backoff <= 0 {
+		c.Backoff = le.config.RetryPeriod
+	}
+	terms := 0
+	le.run(ctx, func(reason StopReason) (time.Duration, bool) {
+		terms++
+		if c.OnStoppedLeading != nil {
+			c.OnStoppedLeading(terms, reason)
+		}
+		if reason == StopContext || (c.MaxTerms > 0 && terms >= c.MaxTerms) {
+			return 0, false
+		}
+		return wait.Jitter(c.Backoff, JitterFactor), true
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// ErrUnsupported is returned by the operations the Lock does not support.
+var ErrUnsupported = errors.New("not supported by the lock")
+
+// Candidates returns the candidates registered by the LeaderElectors running
+// on the same lock, sorted by identity. The entries whose last heartbeat is
+// older than LeaseDuration are ignored, which relies on the candidates clocks
+// being roughly synchronized.
+//
+// It returns ErrUnsupported if the Lock does not implement CandidateRegistry.
+func (le *LeaderElector) Candidates(ctx context.Context) ([]Candidate, error) {
+	r, ok := le.config.Lock.(CandidateRegistry)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	cs, err := r.Candidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []Candidate
+	for _, c := range cs {
+		if le.clock.Since(c.LastSeen) <= le.config.LeaseDuration {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Identity < out[j].Identity
+	})
+	return out, nil
+}
+
+// heartbeat registers the candidate until ctx is done, refreshing its entry
+// a few times per LeaseDuration so that a single failure does not make it stale.
+func (le *LeaderElector) heartbeat(ctx context.Context, r CandidateRegistry) {
+	jitterUntil(ctx, le.clock, func() {
+		c := Candidate{
+			Identity: le.config.Lock.Identity(),
+			Metadata: le.config.Metadata,
+			LastSeen: le.clock.Now(),
+		}
+		if err := r.Register(ctx, c); err != nil && ctx.Err() == nil {
+			le.log.Error(err, "failed to register candidate")
+			le.emit(BackendError, err)
+		}
+	}, le.config.LeaseDuration/3, 0)
+}
+
+// unregister removes the candidate entry, giving up after RenewDeadline.
+func (le *LeaderElector) unregister(r CandidateRegistry) {
+	ctx, cancel := withTimeout(context.Background(), le.clock, le.config.RenewDeadline)
+	defer cancel()
+	if err := r.Unregister(ctx); err != nil {
+		le.log.Error(err, "failed to unregister candidate")
+		le.emit(BackendError, err)
+	}
+}
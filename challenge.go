@@ -0,0 +1,83 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"fmt"
+)
+
+// ChallengeType is the type of a Challenge.
+type ChallengeType int
+
+const (
+	// ChallengeForeignWrite means that the leader read a record it did not
+	// write. The contender is the holder of the record, which may be the
+	// leader identity used by another process.
+	ChallengeForeignWrite ChallengeType = iota
+	// ChallengeConflict means that the leader update of the record conflicted
+	// with another write. The contender is not known yet.
+	ChallengeConflict
+	// ChallengeContender means that another candidate asked the leader to
+	// yield, see Config.Priority.
+	ChallengeContender
+	// ChallengeStalled means that a follower did not observe any renewal of
+	// the lease for more than RenewDeadline. The contender is the stalled leader.
+	ChallengeStalled
+)
+
+func (t ChallengeType) String() string {
+	switch t {
+	case ChallengeForeignWrite:
+		return "ForeignWrite"
+	case ChallengeConflict:
+		return "Conflict"
+	case ChallengeContender:
+		return "Contender"
+	case ChallengeStalled:
+		return "Stalled"
+	default:
+		return fmt.Sprintf("ChallengeType(%d)", int(t))
+	}
+}
+
+// Challenge describes a contest of the lease, see Callbacks.OnChallenge.
+type Challenge struct {
+	Type ChallengeType
+	// Contender is the identity contesting the lease, if known.
+	Contender string
+	// Record is the record read when the challenge was detected, or the
+	// last observed one.
+	Record Record
+	// Err is the error of the ChallengeConflict challenges.
+	Err error
+}
+
+// notifyChallenge dispatches a Challenged event.
+func (le *LeaderElector) notifyChallenge(t ChallengeType, contender string, r Record, err error) {
+	e := le.event(Challenged, err)
+	e.Challenge = &Challenge{Type: t, Contender: contender, Record: r, Err: err}
+	le.dispatcher.push(dispatch{event: e})
+}
+
+// written returns whether r is the record w written by the client, comparing
+// the fields every backend stores as is.
+func written(r, w *Record) bool {
+	return r.HolderIdentity == w.HolderIdentity &&
+		r.LeaderTransitions == w.LeaderTransitions &&
+		r.AcquireTime == w.AcquireTime &&
+		r.RenewTime == w.RenewTime &&
+		r.TransferTo == w.TransferTo &&
+		r.Challenger == w.Challenger
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+)
+
+// jitterUntil calls f every period, jittered by jitterFactor, until ctx is done.
+// It is wait.JitterUntil driven by the clock c.
+func jitterUntil(ctx context.Context, c clock.Clock, f func(), period time.Duration, jitterFactor float64) {
+	for ctx.Err() == nil {
+		f()
+		d := period
+		if jitterFactor > 0 {
+			d = wait.Jitter(period, jitterFactor)
+		}
+		if !sleep(ctx, c, d) {
+			return
+		}
+	}
+}
+
+// pollImmediateUntil calls condition every period, starting immediately, until it
+// returns true or ctx is done. It returns the cause of ctx being done, if any.
+// It is wait.PollImmediateUntil driven by the clock c.
+func pollImmediateUntil(ctx context.Context, c clock.Clock, period time.Duration, condition func() bool) error {
+	for !condition() {
+		if !sleep(ctx, c, period) {
+			return context.Cause(ctx)
+		}
+	}
+	return nil
+}
+
+// sleep waits for d on the clock c. It returns false if ctx is done first.
+func sleep(ctx context.Context, c clock.Clock, d time.Duration) bool {
+	t := c.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C():
+		return true
+	}
+}
+
+// withTimeout is context.WithTimeout driven by the clock c: the returned
+// context is cancelled with context.DeadlineExceeded as cause once d elapsed.
+func withTimeout(ctx context.Context, c clock.Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(clock.RealClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	t := c.NewTimer(d)
+	go func() {
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+		case <-t.C():
+			cancel(context.DeadlineExceeded)
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
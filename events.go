@@ -0,0 +1,201 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// AcquireAttempt is emitted before each attempt to acquire the lease.
+	AcquireAttempt EventType = iota
+	// Acquired is emitted when the client starts leading.
+	Acquired
+	// Renewed is emitted each time the leader renewed the lease.
+	Renewed
+	// RenewFailed is emitted when the leader could not renew the lease
+	// before the RenewDeadline. It is followed by Lost.
+	RenewFailed
+	// Lost is emitted when the client stops leading without having
+	// released the lease.
+	Lost
+	// Released is emitted when the client stops leading after having
+	// released or transferred the lease.
+	Released
+	// NewLeaderObserved is emitted when the client observes a leader that
+	// is not the previously observed leader, the client included.
+	NewLeaderObserved
+	// BackendError is emitted when a Lock operation failed, except for the
+	// expected conflicts and missing records.
+	BackendError
+	// Challenged is emitted when the lease is contested, see Challenge.
+	Challenged
+)
+
+func (t EventType) String() string {
+	switch t {
+	case AcquireAttempt:
+		return "AcquireAttempt"
+	case Acquired:
+		return "Acquired"
+	case Renewed:
+		return "Renewed"
+	case RenewFailed:
+		return "RenewFailed"
+	case Lost:
+		return "Lost"
+	case Released:
+		return "Released"
+	case NewLeaderObserved:
+		return "NewLeaderObserved"
+	case BackendError:
+		return "BackendError"
+	case Challenged:
+		return "Challenged"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is a LeaderElector lifecycle event.
+type Event struct {
+	Type EventType
+	// Record is the last observed record when the event occurred.
+	Record Record
+	// Err is the error of the RenewFailed and BackendError events.
+	Err error
+	// Time is the time at which the event occurred.
+	Time time.Time
+	// ObservedTime is the time at which Record was observed.
+	ObservedTime time.Time
+	// Challenge is the challenge of the Challenged events.
+	Challenge *Challenge
+}
+
+// Events returns the stream of the elector events, in the order they occurred.
+// Only the events occurring after the first call are sent, and they are
+// buffered without limit until received, so the channel must be drained.
+// All the calls return the same channel, which is never closed.
+func (le *LeaderElector) Events() <-chan Event {
+	le.streamLock.Lock()
+	defer le.streamLock.Unlock()
+	if le.stream == nil {
+		ch := make(chan Event)
+		le.events = ch
+		le.stream = &queue[Event]{handle: func(e Event) {
+			ch <- e
+		}}
+	}
+	return le.events
+}
+
+// dispatch is an event queued in the elector dispatcher.
+type dispatch struct {
+	event Event
+	// ctx is the context given to OnStartedLeading, and runnables the one
+	// the Runnables contexts are derived from
+	ctx       context.Context
+	runnables context.Context
+	// done, if set, is closed once the event was dispatched
+	done chan struct{}
+}
+
+// emit queues an event of type t in the dispatcher.
+func (le *LeaderElector) emit(t EventType, err error) {
+	le.dispatcher.push(dispatch{event: le.event(t, err)})
+}
+
+func (le *LeaderElector) event(t EventType, err error) Event {
+	r, _ := le.observed.get()
+	return Event{
+		Type:         t,
+		Record:       r,
+		Err:          err,
+		Time:         le.clock.Now(),
+		ObservedTime: le.observed.at(),
+	}
+}
+
+// handle dispatches an event to the callbacks, the runnables and the events
+// stream. OnStartedLeading runs until the leading context is done, and is thus
+// the only callback run in its own goroutine.
+func (le *LeaderElector) handle(d dispatch) {
+	cb := le.config.Callbacks
+	switch d.event.Type {
+	case Acquired:
+		go cb.OnStartedLeading(d.ctx)
+		le.startRunnables(d.runnables)
+	case Lost, Released:
+		le.stopRunnables()
+		cb.OnStoppedLeading()
+	case NewLeaderObserved:
+		if cb.OnNewLeader != nil {
+			cb.OnNewLeader(d.event.Record.HolderIdentity)
+		}
+	case Challenged:
+		if cb.OnChallenge != nil {
+			cb.OnChallenge(*d.event.Challenge)
+		}
+	}
+	le.streamLock.Lock()
+	if le.stream != nil {
+		le.stream.push(d.event)
+	}
+	le.streamLock.Unlock()
+	if d.done != nil {
+		close(d.done)
+	}
+}
+
+// queue is an unbounded FIFO queue calling handle on its items, one at a time,
+// from a goroutine running while items are pending.
+type queue[T any] struct {
+	handle func(T)
+
+	mu      sync.Mutex
+	items   []T
+	running bool
+}
+
+func (q *queue[T]) push(v T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, v)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+func (q *queue[T]) run() {
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		v := q.items[0]
+		q.items = q.items[1:]
+		q.mu.Unlock()
+		q.handle(v)
+	}
+}
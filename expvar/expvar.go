@@ -0,0 +1,177 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package expvar implements a leaderelection.ExtendedMetricsProvider exposing
+// the metrics of the LeaderElectors with the standard expvar package.
+//
+// The Provider is an expvar.Var holding a map of the metrics by elector name:
+//
+//	{"my-lock": {"leader": 1, "transitions": 2, "renew_failures": {"timeout": 1},
+//	  "lease_age_seconds": 12.5, "since_renew_seconds": 0.2, "clock_skew_seconds": 0.01,
+//	  "latency": {"renew/update": {"count": 6, "sum_seconds": 0.03}}}}
+//
+// It is not published by New, so that tests can create fresh providers:
+//
+//	p := expvar.New()
+//	stdexpvar.Publish("leader_election", p)
+//	le.SetProvider(p)
+package expvar
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	le "go.linka.cloud/leaderelection"
+)
+
+var (
+	_ le.ExtendedMetricsProvider = (*Provider)(nil)
+	_ expvar.Var                 = (*Provider)(nil)
+)
+
+// Provider is a leaderelection.ExtendedMetricsProvider recording the metrics in
+// expvar maps.
+type Provider struct {
+	mu    sync.Mutex
+	names expvar.Map
+}
+
+// New creates a Provider.
+func New() *Provider {
+	p := &Provider{}
+	p.names.Init()
+	return p
+}
+
+// String returns the metrics as JSON, implementing expvar.Var.
+func (p *Provider) String() string {
+	return p.names.String()
+}
+
+// Get returns the metrics of the elector with the given name, or nil if none
+// were recorded yet.
+func (p *Provider) Get(name string) *expvar.Map {
+	m, _ := p.names.Get(name).(*expvar.Map)
+	return m
+}
+
+// metrics returns the metrics of the elector with the given name, creating them if needed.
+func (p *Provider) metrics(name string) *expvar.Map {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if m, ok := p.names.Get(name).(*expvar.Map); ok {
+		return m
+	}
+	m := new(expvar.Map).Init()
+	p.names.Set(name, m)
+	return m
+}
+
+// child returns the map stored under key in m, creating it if needed.
+func (p *Provider) child(m *expvar.Map, key string) *expvar.Map {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := m.Get(key).(*expvar.Map); ok {
+		return c
+	}
+	c := new(expvar.Map).Init()
+	m.Set(key, c)
+	return c
+}
+
+func (p *Provider) NewLeaderMetric() le.SwitchMetric {
+	return switchMetric{p}
+}
+
+func (p *Provider) NewLatencyMetric() le.LatencyMetric {
+	return latencyMetric{p}
+}
+
+func (p *Provider) NewTransitionsMetric() le.CounterMetric {
+	return counterMetric{p, "transitions"}
+}
+
+func (p *Provider) NewRenewFailuresMetric() le.ClassCounterMetric {
+	return classCounterMetric{p, "renew_failures"}
+}
+
+func (p *Provider) NewLeaseAgeMetric() le.DurationMetric {
+	return durationMetric{p, "lease_age_seconds"}
+}
+
+func (p *Provider) NewSinceRenewMetric() le.DurationMetric {
+	return durationMetric{p, "since_renew_seconds"}
+}
+
+func (p *Provider) NewClockSkewMetric() le.DurationMetric {
+	return durationMetric{p, "clock_skew_seconds"}
+}
+
+type switchMetric struct {
+	p *Provider
+}
+
+func (s switchMetric) On(name string) {
+	s.set(name, 1)
+}
+
+func (s switchMetric) Off(name string) {
+	s.set(name, 0)
+}
+
+func (s switchMetric) set(name string, v int64) {
+	i := new(expvar.Int)
+	i.Set(v)
+	s.p.metrics(name).Set("leader", i)
+}
+
+type latencyMetric struct {
+	p *Provider
+}
+
+func (l latencyMetric) Observe(name, phase, operation string, d time.Duration) {
+	m := l.p.child(l.p.child(l.p.metrics(name), "latency"), phase+"/"+operation)
+	m.Add("count", 1)
+	m.AddFloat("sum_seconds", d.Seconds())
+}
+
+type counterMetric struct {
+	p   *Provider
+	key string
+}
+
+func (c counterMetric) Inc(name string) {
+	c.p.metrics(name).Add(c.key, 1)
+}
+
+type classCounterMetric struct {
+	p   *Provider
+	key string
+}
+
+func (c classCounterMetric) Inc(name, class string) {
+	c.p.child(c.p.metrics(name), c.key).Add(class, 1)
+}
+
+type durationMetric struct {
+	p   *Provider
+	key string
+}
+
+func (g durationMetric) Set(name string, d time.Duration) {
+	f := new(expvar.Float)
+	f.Set(d.Seconds())
+	g.p.metrics(name).Set(g.key, f)
+}
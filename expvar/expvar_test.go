@@ -0,0 +1,74 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expvar
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestProvider(t *testing.T) {
+	p := New()
+	p.NewLeaderMetric().On("test")
+	p.NewLatencyMetric().Observe("test", "renew", "update", 100*time.Millisecond)
+	p.NewLatencyMetric().Observe("test", "renew", "update", 300*time.Millisecond)
+	p.NewTransitionsMetric().Inc("test")
+	p.NewRenewFailuresMetric().Inc("test", "timeout")
+	p.NewLeaseAgeMetric().Set("test", 12*time.Second)
+	p.NewSinceRenewMetric().Set("test", time.Second)
+	p.NewClockSkewMetric().Set("test", -time.Second)
+	p.NewLeaderMetric().On("other")
+	p.NewLeaderMetric().Off("other")
+
+	var got map[string]map[string]any
+	if err := json.Unmarshal([]byte(p.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]any{
+		"test": {
+			"leader":              1.0,
+			"transitions":         1.0,
+			"renew_failures":      map[string]any{"timeout": 1.0},
+			"lease_age_seconds":   12.0,
+			"since_renew_seconds": 1.0,
+			"clock_skew_seconds":  -1.0,
+			"latency": map[string]any{
+				"renew/update": map[string]any{"count": 2.0, "sum_seconds": 0.4},
+			},
+		},
+		"other": {
+			"leader": 0.0,
+		},
+	}
+	for name, m := range want {
+		for k, v := range m {
+			if g, _ := json.Marshal(got[name][k]); string(g) != mustMarshal(t, v) {
+				t.Errorf("%s: got %s %s, want %s", name, k, g, mustMarshal(t, v))
+			}
+		}
+	}
+	if p.Get("test") == nil || p.Get("none") != nil {
+		t.Error("unexpected metrics returned by Get")
+	}
+}
+
+func mustMarshal(t *testing.T, v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
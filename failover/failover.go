@@ -0,0 +1,321 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package failover implements a lock backend using a primary lock, and falling
+// back to a secondary lock when the primary has been unreachable for a while.
+//
+// The leader renews its lease on the active lock, and mirrors it on the other
+// one when it can. Get reads both locks and returns the record of the most
+// recent term, so that the candidates failing over to the secondary see the
+// lease of a leader still renewing on the primary, and a leader still renewing
+// on the primary steps down as soon as it sees the term started on the secondary.
+//
+// The secondary lock arbitrates the fencing tokens: each term is started on the
+// secondary before the primary. A leader elected on the secondary is thus always
+// given a higher token than the leaders elected before on the primary, which
+// protects the downstream systems from a leader the candidates could not reach.
+// The downside is that no term can be started while the secondary is unreachable.
+package failover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	le "go.linka.cloud/leaderelection"
+)
+
+var (
+	_ le.Lock         = (*Lock)(nil)
+	_ le.LoggerSetter = (*Lock)(nil)
+)
+
+// Config configures a failover Lock.
+type Config struct {
+	// Primary is the lock used while it is reachable.
+	Primary le.Lock
+	// Secondary is the lock used once the primary has been unreachable for
+	// FailoverAfter. It must have the same identity as the primary.
+	Secondary le.Lock
+	// FailoverAfter is the duration the primary must have been unreachable
+	// for before failing over to the secondary. It should be greater than the
+	// elector LeaseDuration.
+	FailoverAfter time.Duration
+	// OnSwitch, if set, is called with the new active lock each time the Lock
+	// switches from one lock to the other.
+	OnSwitch func(active le.Lock)
+	// Clock defaults to the real clock.
+	Clock clock.PassiveClock
+	// Logger defaults to the klog logger, see also Lock.SetLogger.
+	Logger logr.Logger
+}
+
+// Lock is a le.Lock on a primary lock, falling back to a secondary lock.
+type Lock struct {
+	config Config
+	log    logr.Logger
+
+	mu sync.Mutex
+	// failedOver is true while the secondary lock is active
+	failedOver bool
+	// reachable is the last time the primary lock was reachable
+	reachable time.Time
+	// term is the term of the last read or written record, if any
+	term  int
+	known bool
+}
+
+// New returns a failover Lock.
+func New(c Config) (*Lock, error) {
+	if c.Primary == nil || c.Secondary == nil {
+		return nil, errors.New("failover: primary and secondary locks must not be nil")
+	}
+	if c.Primary.Identity() != c.Secondary.Identity() {
+		return nil, fmt.Errorf("failover: secondary identity %q differs from %q", c.Secondary.Identity(), c.Primary.Identity())
+	}
+	if c.FailoverAfter <= 0 {
+		return nil, errors.New("failover: failoverAfter must be greater than zero")
+	}
+	if c.Clock == nil {
+		c.Clock = clock.RealClock{}
+	}
+	if c.Logger.GetSink() == nil {
+		c.Logger = klog.Background()
+	}
+	return &Lock{config: c, log: c.Logger.WithValues("identity", c.Primary.Identity()), reachable: c.Clock.Now()}, nil
+}
+
+// Active returns the lock currently in use.
+func (l *Lock) Active() le.Lock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active()
+}
+
+// active must be called with mu held.
+func (l *Lock) active() le.Lock {
+	if l.failedOver {
+		return l.config.Secondary
+	}
+	return l.config.Primary
+}
+
+type result struct {
+	record  *le.Record
+	version le.Version
+	err     error
+}
+
+func (l *Lock) Get(ctx context.Context) (*le.Record, []byte, le.Version, error) {
+	var (
+		wg   sync.WaitGroup
+		p, s result
+	)
+	for _, v := range []struct {
+		l le.Lock
+		r *result
+	}{{l.config.Primary, &p}, {l.config.Secondary, &s}} {
+		v := v
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v.r.record, _, v.r.version, v.r.err = v.l.Get(ctx)
+		}()
+	}
+	wg.Wait()
+
+	failedOver := l.update(reachable(p.err))
+	switch {
+	case !failedOver && !reachable(p.err):
+		return nil, nil, "", p.err
+	case failedOver && !reachable(s.err):
+		return nil, nil, "", s.err
+	}
+	// the record of the most recent term
+	var ler *le.Record
+	for _, r := range []result{p, s} {
+		if r.err == nil && (ler == nil || newer(r.record, ler)) {
+			ler = r.record
+		}
+	}
+	l.mu.Lock()
+	l.known = ler != nil
+	if ler != nil {
+		l.term = ler.LeaderTransitions
+	}
+	l.mu.Unlock()
+	if ler == nil {
+		return nil, nil, "", fmt.Errorf("%s: %w", l.Describe(), os.ErrNotExist)
+	}
+	// the raw record is marshalled again, so that it does not change with
+	// the lock it was read from
+	raw, err := json.Marshal(ler)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return ler, raw, encodeVersion(p.version, s.version), nil
+}
+
+func (l *Lock) Create(ctx context.Context, ler le.Record) (le.Version, error) {
+	return l.write(ctx, ler, "", "")
+}
+
+func (l *Lock) Update(ctx context.Context, ler le.Record, version le.Version) (le.Version, error) {
+	p, s, err := decodeVersion(version)
+	if err != nil {
+		return "", &le.ConflictError{Lock: l.Describe(), Version: version, Err: err}
+	}
+	return l.write(ctx, ler, p, s)
+}
+
+// write starts a new term on the secondary, then on the primary if it is
+// active, or renews the lease on the active lock, mirroring it on the other.
+func (l *Lock) write(ctx context.Context, ler le.Record, p, s le.Version) (le.Version, error) {
+	l.mu.Lock()
+	failedOver := l.failedOver
+	term := !l.known || ler.LeaderTransitions != l.term
+	l.mu.Unlock()
+
+	var err error
+	if term || failedOver {
+		if s, err = set(ctx, l.config.Secondary, ler, s); err != nil {
+			return "", err
+		}
+		if !failedOver {
+			if p, err = set(ctx, l.config.Primary, ler, p); err != nil {
+				return "", err
+			}
+		}
+	} else {
+		if p, err = set(ctx, l.config.Primary, ler, p); err != nil {
+			return "", err
+		}
+		if s, err = set(ctx, l.config.Secondary, ler, s); err != nil {
+			l.log.V(4).Info("failed to mirror the lease", "lock", l.config.Secondary.Describe(), "reason", err)
+			s = ""
+		}
+	}
+	l.mu.Lock()
+	l.term, l.known = ler.LeaderTransitions, true
+	l.mu.Unlock()
+	return encodeVersion(p, s), nil
+}
+
+// Consistency returns le.CompareAndSwap if both locks do.
+func (l *Lock) Consistency() le.Consistency {
+	if l.config.Primary.Consistency() == le.CompareAndSwap && l.config.Secondary.Consistency() == le.CompareAndSwap {
+		return le.CompareAndSwap
+	}
+	return le.BestEffort
+}
+
+// SetLogger replaces the logger of the Lock and of its locks implementing
+// le.LoggerSetter, with their description as the member value.
+func (l *Lock) SetLogger(log logr.Logger) {
+	l.log = log
+	for _, m := range []le.Lock{l.config.Primary, l.config.Secondary} {
+		if s, ok := m.(le.LoggerSetter); ok {
+			s.SetLogger(log.WithValues("member", m.Describe()))
+		}
+	}
+}
+
+// RecordEvent records the event on the active lock.
+func (l *Lock) RecordEvent(s string) {
+	l.Active().RecordEvent(s)
+}
+
+func (l *Lock) Identity() string {
+	return l.config.Primary.Identity()
+}
+
+// Describe describes the lock, starting with the active one.
+func (l *Lock) Describe() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failedOver {
+		return fmt.Sprintf("failover[active=%s,standby=%s]", l.config.Secondary.Describe(), l.config.Primary.Describe())
+	}
+	return fmt.Sprintf("failover[active=%s,standby=%s]", l.config.Primary.Describe(), l.config.Secondary.Describe())
+}
+
+// update records whether the primary is reachable, switches the active lock
+// accordingly, and returns whether the Lock failed over to the secondary.
+func (l *Lock) update(primary bool) bool {
+	now := l.config.Clock.Now()
+	l.mu.Lock()
+	was := l.failedOver
+	if primary {
+		l.reachable = now
+		l.failedOver = false
+	} else if now.Sub(l.reachable) >= l.config.FailoverAfter {
+		l.failedOver = true
+	}
+	failedOver, active := l.failedOver, l.active()
+	l.mu.Unlock()
+	if failedOver == was {
+		return failedOver
+	}
+	msg := "switched back to primary lock " + active.Describe()
+	if failedOver {
+		msg = "switched to secondary lock " + active.Describe()
+	}
+	l.log.Info("switched the active lock", "active", active.Describe(), "failedOver", failedOver)
+	active.RecordEvent(msg)
+	if l.config.OnSwitch != nil {
+		l.config.OnSwitch(active)
+	}
+	return failedOver
+}
+
+// reachable returns whether a Get error means that the lock is reachable.
+func reachable(err error) bool {
+	return err == nil || errors.Is(err, os.ErrNotExist)
+}
+
+func set(ctx context.Context, l le.Lock, ler le.Record, version le.Version) (le.Version, error) {
+	if version == "" {
+		return l.Create(ctx, ler)
+	}
+	return l.Update(ctx, ler, version)
+}
+
+// newer returns whether a is more recent than b.
+func newer(a, b *le.Record) bool {
+	if a.LeaderTransitions != b.LeaderTransitions {
+		return a.LeaderTransitions > b.LeaderTransitions
+	}
+	return a.RenewTime > b.RenewTime
+}
+
+func encodeVersion(primary, secondary le.Version) le.Version {
+	b, _ := json.Marshal([]le.Version{primary, secondary})
+	return le.Version(b)
+}
+
+func decodeVersion(version le.Version) (primary, secondary le.Version, err error) {
+	var vs []le.Version
+	if err := json.Unmarshal([]byte(version), &vs); err != nil || len(vs) != 2 {
+		return "", "", fmt.Errorf("invalid version %q", version)
+	}
+	return vs[0], vs[1], nil
+}
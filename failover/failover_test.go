@@ -0,0 +1,176 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package failover
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/leaderelectiontest"
+	"go.linka.cloud/leaderelection/memory"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	retryPeriod   = 2 * time.Second
+	failoverAfter = 20 * time.Second
+)
+
+func TestLockConformance(t *testing.T) {
+	ps, ss := memory.NewStore(), memory.NewStore()
+	leaderelectiontest.RunLockConformance(t, func(t *testing.T, name, id string) le.Lock {
+		l, err := New(Config{
+			Primary:       memory.New(ps, name, id),
+			Secondary:     memory.New(ss, name, id),
+			FailoverAfter: time.Minute,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	})
+}
+
+type elector struct {
+	lock     *Lock
+	switches atomic.Int32
+	leading  atomic.Bool
+}
+
+func run(t *testing.T, ctx context.Context, ps, ss *memory.Store, clk *clocktesting.FakeClock, id string) *elector {
+	e := &elector{}
+	var err error
+	e.lock, err = New(Config{
+		Primary:       memory.New(ps, "test", id),
+		Secondary:     memory.New(ss, "test", id),
+		FailoverAfter: failoverAfter,
+		OnSwitch: func(le.Lock) {
+			e.switches.Add(1)
+		},
+		Clock: clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	el, err := le.New(le.Config{
+		Lock:          e.lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   retryPeriod,
+		Clock:         clk,
+		Callbacks: le.Callbacks{
+			OnStartedLeading: func(context.Context) {
+				e.leading.Store(true)
+			},
+			OnStoppedLeading: func() {
+				e.leading.Store(false)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go el.Campaign(ctx, le.CampaignConfig{})
+	return e
+}
+
+// stepUntil steps the fake clock until cond returns true, failing after max.
+func stepUntil(t *testing.T, clk *clocktesting.FakeClock, max time.Duration, cond func() bool) {
+	t.Helper()
+	start := clk.Now()
+	for !cond() {
+		if clk.Since(start) > max {
+			t.Fatalf("condition not met after %v", max)
+		}
+		clk.Step(retryPeriod / 4)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ps, ss := memory.NewStore(), memory.NewStore()
+	clk := clocktesting.NewFakeClock(time.Now())
+	a := run(t, ctx, ps, ss, clk, "a")
+	stepUntil(t, clk, retryPeriod, a.leading.Load)
+	b := run(t, ctx, ps, ss, clk, "b")
+
+	// the primary is down for everyone
+	ps.SetFaults("", memory.Faults{ErrorRate: 1})
+	stepUntil(t, clk, 2*failoverAfter, func() bool {
+		return a.switches.Load() == 1 && b.switches.Load() == 1
+	})
+	if d := a.lock.Describe(); !strings.HasPrefix(d, "failover[active=memory/test,") || a.lock.Active() != a.lock.config.Secondary {
+		t.Errorf("got active lock %s, want the secondary", d)
+	}
+	stepUntil(t, clk, 2*leaseDuration, func() bool {
+		return a.leading.Load() || b.leading.Load()
+	})
+	r, _ := ss.Record("test")
+	if r.LeaderTransitions != 1 {
+		t.Errorf("got term %d on the secondary, want 1", r.LeaderTransitions)
+	}
+
+	// the leader moves back to the primary, keeping its term
+	ps.SetFaults("", memory.Faults{})
+	stepUntil(t, clk, 2*retryPeriod, func() bool {
+		r, _ := ps.Record("test")
+		return r.LeaderTransitions == 1 && a.switches.Load() == 2 && b.switches.Load() == 2
+	})
+}
+
+func TestFencing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ps, ss := memory.NewStore(), memory.NewStore()
+	clk := clocktesting.NewFakeClock(time.Now())
+	a := run(t, ctx, ps, ss, clk, "a")
+	stepUntil(t, clk, retryPeriod, a.leading.Load)
+
+	// b fails over, but sees the lease renewed by a on the secondary
+	b := run(t, ctx, ps, ss, clk, "b")
+	ps.SetFaults("b", memory.Faults{ErrorRate: 1})
+	stepUntil(t, clk, 2*failoverAfter, func() bool {
+		return b.switches.Load() == 1
+	})
+	for i := 0; i < 2*int(leaseDuration/retryPeriod); i++ {
+		clk.Step(retryPeriod)
+		time.Sleep(time.Millisecond)
+	}
+	if b.leading.Load() || !a.leading.Load() {
+		t.Fatal("b started leading on the secondary while a was renewing on the primary")
+	}
+
+	// a loses the secondary, and b its lease: b starts a new term that a sees
+	// as soon as the secondary is back
+	ss.SetFaults("a", memory.Faults{ErrorRate: 1})
+	stepUntil(t, clk, 2*leaseDuration, b.leading.Load)
+	ss.SetFaults("a", memory.Faults{})
+	stepUntil(t, clk, 2*leaseDuration, func() bool {
+		return !a.leading.Load()
+	})
+	pr, _ := ps.Record("test")
+	sr, _ := ss.Record("test")
+	if sr.HolderIdentity != "b" || sr.LeaderTransitions <= pr.LeaderTransitions {
+		t.Errorf("got record %+v on the secondary and %+v on the primary", sr, pr)
+	}
+}
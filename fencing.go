@@ -0,0 +1,43 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+)
+
+type tokenKey struct{}
+
+// TokenFromContext returns the fencing token of the leadership term bound to
+// ctx. The context given to Callbacks.OnStartedLeading always carries a token.
+//
+// The token is derived from Record.LeaderTransitions, starts at 1 so that the
+// zero value is never a valid token, and strictly increases with each
+// leadership term: storage layers guarded by the leader election should
+// remember the highest token they have seen and reject the writes carrying a
+// lower one.
+func TokenFromContext(ctx context.Context) (int64, bool) {
+	t, ok := ctx.Value(tokenKey{}).(int64)
+	return t, ok
+}
+
+func withToken(ctx context.Context, token int64) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// tokenOf returns the fencing token of the term r was written in.
+func tokenOf(r *Record) int64 {
+	return int64(r.LeaderTransitions) + 1
+}
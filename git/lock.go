@@ -20,31 +20,50 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"os"
+	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/storage/memory"
-	"github.com/sirupsen/logrus"
-	apierrs "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
 
 	le "go.linka.cloud/leaderelection"
 )
 
-var _ le.Lock = (*lock)(nil)
+var (
+	_ le.Lock              = (*lock)(nil)
+	_ le.CandidateRegistry = (*lock)(nil)
+	_ le.LoggerSetter      = (*lock)(nil)
+)
+
+// maxPushRetries is the number of times a change is applied again on top of
+// the remote head when it moved before the change was pushed.
+const maxPushRetries = 5
 
 type lock struct {
 	name string
 	auth transport.AuthMethod
 	repo *git.Repository
 	id   string
+	log  logr.Logger
 	mu   sync.RWMutex
 }
 
+// New clones the repository at url and returns a lock on the file name. The
+// lock logs with the logger of ctx (see klog.NewContext), which defaults to
+// the klog logger, until SetLogger replaces it.
 func New(ctx context.Context, name, url string, auth transport.AuthMethod, id string) (le.Lock, error) {
 	r, err := git.CloneContext(ctx, memory.NewStorage(), memfs.New(), &git.CloneOptions{
 		URL:  url,
@@ -63,51 +82,65 @@ func New(ctx context.Context, name, url string, auth transport.AuthMethod, id st
 	if err != nil {
 		return nil, err
 	}
-	return &lock{name: name, auth: auth, repo: r, id: id}, nil
+	log := klog.FromContext(ctx).WithValues("lock", name, "identity", id)
+	return &lock{name: name, auth: auth, repo: r, id: id, log: log}, nil
 }
 
-func (l *lock) Get(ctx context.Context) (*le.Record, []byte, error) {
+func (l *lock) Get(ctx context.Context) (*le.Record, []byte, le.Version, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 	w, err := l.repo.Worktree()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get worktree: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to get worktree: %w", err)
 	}
-	if err := w.PullContext(ctx, &git.PullOptions{Auth: l.auth, Force: true}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+	if err := l.pullContext(ctx, w); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
 		if errorContains(err, "remote repository is empty") {
-			return nil, nil, apierrs.NewNotFound(schema.GroupResource{}, l.name)
+			return nil, nil, "", fmt.Errorf("%s: %w", l.name, os.ErrNotExist)
 		}
-		return nil, nil, fmt.Errorf("failed to pull: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to pull: %w", err)
 	}
 	f, err := w.Filesystem.Open(l.name)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to open file: %w", err)
 	}
 	defer f.Close()
 	r := &le.Record{}
 	b, err := io.ReadAll(f)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read file: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to read file: %w", err)
 	}
 	if err := json.Unmarshal(b, &r); err != nil {
-		return nil, nil, fmt.Errorf("failed to decode: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to decode: %w", err)
 	}
 	if err := w.Clean(&git.CleanOptions{Dir: true}); err != nil {
-		return nil, nil, fmt.Errorf("failed to clean: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to clean: %w", err)
 	}
-	return r, b, nil
+	return r, b, blobVersion(b), nil
+}
+
+func (l *lock) Create(ctx context.Context, ler le.Record) (le.Version, error) {
+	return l.set(ctx, ler, "", true)
 }
 
-func (l *lock) Create(ctx context.Context, ler le.Record) error {
-	return l.set(ctx, ler)
+func (l *lock) Update(ctx context.Context, ler le.Record, version le.Version) (le.Version, error) {
+	return l.set(ctx, ler, version, false)
 }
 
-func (l *lock) Update(ctx context.Context, ler le.Record) error {
-	return l.set(ctx, ler)
+// Consistency returns le.CompareAndSwap: the version is the hash of the record
+// blob, which is only pushed on top of a head holding the expected one, and
+// the remote rejects the non-fast-forward pushes. The commits of the candidates
+// thus do not move the version of the record.
+func (l *lock) Consistency() le.Consistency {
+	return le.CompareAndSwap
 }
 
 func (l *lock) RecordEvent(m string) {
-	logrus.Infof("lock event: %s", m)
+	l.log.Info("lock event", "event", m)
+}
+
+// SetLogger replaces the logger of the lock, see le.LoggerSetter.
+func (l *lock) SetLogger(log logr.Logger) {
+	l.log = log
 }
 
 func (l *lock) Identity() string {
@@ -118,51 +151,249 @@ func (l *lock) Describe() string {
 	return l.name
 }
 
-func (l *lock) set(ctx context.Context, ler le.Record) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	w, err := l.repo.Worktree()
+func (l *lock) set(ctx context.Context, ler le.Record, version le.Version, create bool) (le.Version, error) {
+	b, err := json.Marshal(ler)
 	if err != nil {
-		return fmt.Errorf("failed to get worktree: %w", err)
+		return "", fmt.Errorf("failed to encode: %w", err)
 	}
-	h, err := l.repo.Head()
+	err = l.change(ctx, fmt.Sprintf("%s lock", ler.HolderIdentity), func(w *git.Worktree, h *plumbing.Reference) error {
+		current, err := l.recordVersion(h)
+		if err != nil {
+			return err
+		}
+		if create && current != "" {
+			return &le.ConflictError{Lock: l.Describe(), Err: errors.New("record already exists")}
+		}
+		if !create && (current == "" || current != version) {
+			return &le.ConflictError{Lock: l.Describe(), Version: version}
+		}
+		if err := util.WriteFile(w.Filesystem, l.name, b, 0644); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+		if _, err := w.Add(l.name); err != nil {
+			return fmt.Errorf("failed to add file: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get head: %w", err)
+		return "", err
 	}
-	f, err := w.Filesystem.Create(l.name)
+	return blobVersion(b), nil
+}
+
+// Register writes and pushes the candidate file of the lock identity.
+func (l *lock) Register(ctx context.Context, c le.Candidate) error {
+	b, err := json.Marshal(c)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return fmt.Errorf("failed to encode: %w", err)
 	}
-	defer f.Close()
-	b, err := json.Marshal(ler)
+	return l.change(ctx, fmt.Sprintf("%s candidate", l.id), func(w *git.Worktree, _ *plumbing.Reference) error {
+		if err := util.WriteFile(w.Filesystem, l.candidateFile(), b, 0644); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+		if _, err := w.Add(l.candidateFile()); err != nil {
+			return fmt.Errorf("failed to add file: %w", err)
+		}
+		return nil
+	})
+}
+
+// Unregister removes the candidate file of the lock identity.
+func (l *lock) Unregister(ctx context.Context) error {
+	return l.change(ctx, fmt.Sprintf("%s left", l.id), func(w *git.Worktree, _ *plumbing.Reference) error {
+		if _, err := w.Filesystem.Stat(l.candidateFile()); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if _, err := w.Remove(l.candidateFile()); err != nil {
+			return fmt.Errorf("failed to remove file: %w", err)
+		}
+		return nil
+	})
+}
+
+// Candidates reads the candidate files of the election.
+func (l *lock) Candidates(ctx context.Context) ([]le.Candidate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, err := l.pull(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to encode: %w", err)
+		return nil, err
 	}
-	if _, err := f.Write(b); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	fs, err := w.Filesystem.ReadDir(l.candidatesDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
 	}
-	s, err := w.Status()
 	if err != nil {
-		return fmt.Errorf("failed to get status: %w", err)
+		return nil, fmt.Errorf("failed to read candidates: %w", err)
 	}
-	if s.IsClean() {
-		return nil
+	var cs []le.Candidate
+	for _, f := range fs {
+		b, err := util.ReadFile(w.Filesystem, path.Join(l.candidatesDir(), f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		var c le.Candidate
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", f.Name(), err)
+		}
+		cs = append(cs, c)
 	}
-	if _, err := w.Add(l.name); err != nil {
-		return fmt.Errorf("failed to add file: %w", err)
+	return cs, nil
+}
+
+func (l *lock) candidatesDir() string {
+	return l.name + ".candidates"
+}
+
+func (l *lock) candidateFile() string {
+	return path.Join(l.candidatesDir(), url.PathEscape(l.id)+".json")
+}
+
+// change pulls the remote, applies the change to the worktree on top of the
+// head h, which is nil in an empty repository, and pushes it. The change is
+// applied again on top of the new head if the remote moved in between, e.g.
+// because of another candidate, up to maxPushRetries times.
+func (l *lock) change(ctx context.Context, msg string, change func(w *git.Worktree, h *plumbing.Reference) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := 0; ; i++ {
+		w, err := l.pull(ctx)
+		if err != nil {
+			return err
+		}
+		h, err := l.repo.Head()
+		if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return fmt.Errorf("failed to get head: %w", err)
+		}
+		if err := change(w, h); err != nil {
+			return err
+		}
+		s, err := w.Status()
+		if err != nil {
+			return fmt.Errorf("failed to get status: %w", err)
+		}
+		if s.IsClean() {
+			return nil
+		}
+		err = l.commit(ctx, w, h, msg)
+		if !conflicting(err) {
+			return err
+		}
+		if i == maxPushRetries {
+			return &le.ConflictError{Lock: l.Describe(), Err: err}
+		}
+		l.log.V(4).Info("remote moved, applying the change again", "message", msg)
 	}
-	if _, err := w.Commit(fmt.Sprintf("%s lock", ler.HolderIdentity), &git.CommitOptions{}); err != nil {
+}
+
+// pull pulls the remote, which may be empty, into the worktree.
+func (l *lock) pull(ctx context.Context) (*git.Worktree, error) {
+	w, err := l.repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+	err = l.pullContext(ctx, w)
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) && !errorContains(err, "remote repository is empty") {
+		return nil, fmt.Errorf("failed to pull: %w", err)
+	}
+	return w, nil
+}
+
+// commit commits the staged changes and pushes them, dropping the commit if
+// the push fails.
+func (l *lock) commit(ctx context.Context, w *git.Worktree, h *plumbing.Reference, msg string) error {
+	// do not depend on the user's git configuration
+	if _, err := w.Commit(msg, &git.CommitOptions{
+		Author: &object.Signature{Name: l.id, When: time.Now()},
+	}); err != nil {
 		return fmt.Errorf("failed to commit: %w", err)
 	}
-	if err := l.repo.PushContext(ctx, &git.PushOptions{Auth: l.auth}); err != nil {
-		if err := w.Checkout(&git.CheckoutOptions{Hash: h.Hash()}); err != nil {
-			return fmt.Errorf("failed to checkout: %w", err)
+	if err := l.pushContext(ctx); err != nil {
+		if err := l.rollback(w, h); err != nil {
+			return err
 		}
 		return fmt.Errorf("failed to push: %w", err)
 	}
 	return nil
 }
 
+// pullContext pulls the remote into the worktree within a span.
+func (l *lock) pullContext(ctx context.Context, w *git.Worktree) error {
+	ctx, span := le.StartSpan(ctx, "git.pull", attribute.String("git.file", l.name))
+	err := w.PullContext(ctx, &git.PullOptions{Auth: l.auth, Force: true})
+	switch {
+	case errors.Is(err, git.NoErrAlreadyUpToDate):
+		le.EndSpan(span, nil)
+	case errorContains(err, "remote repository is empty"):
+		le.EndSpan(span, os.ErrNotExist)
+	default:
+		le.EndSpan(span, err)
+	}
+	return err
+}
+
+// pushContext pushes the local commits to the remote within a span.
+func (l *lock) pushContext(ctx context.Context) error {
+	ctx, span := le.StartSpan(ctx, "git.push", attribute.String("git.file", l.name))
+	err := l.repo.PushContext(ctx, &git.PushOptions{Auth: l.auth})
+	if conflicting(err) {
+		le.EndSpan(span, &le.ConflictError{Lock: l.Describe(), Err: err})
+	} else {
+		le.EndSpan(span, err)
+	}
+	return err
+}
+
+// recordVersion returns the version of the record file in the commit
+// referenced by h, empty if there is none.
+func (l *lock) recordVersion(h *plumbing.Reference) (le.Version, error) {
+	if h == nil {
+		return "", nil
+	}
+	c, err := l.repo.CommitObject(h.Hash())
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit: %w", err)
+	}
+	f, err := c.File(l.name)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get file: %w", err)
+	}
+	return le.Version(f.Hash.String()), nil
+}
+
+// blobVersion returns the version of the record file holding b: the hash of
+// its blob.
+func blobVersion(b []byte) le.Version {
+	return le.Version(plumbing.ComputeHash(plumbing.BlobObject, b).String())
+}
+
+// rollback drops the local commit that could not be pushed.
+func (l *lock) rollback(w *git.Worktree, h *plumbing.Reference) error {
+	if h != nil {
+		if err := w.Reset(&git.ResetOptions{Commit: h.Hash(), Mode: git.HardReset}); err != nil {
+			return fmt.Errorf("failed to reset: %w", err)
+		}
+		return nil
+	}
+	head, err := l.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to get head: %w", err)
+	}
+	if err := l.repo.Storer.RemoveReference(head.Target()); err != nil {
+		return fmt.Errorf("failed to reset: %w", err)
+	}
+	return nil
+}
+
+// conflicting returns whether err is the rejection of a push because the
+// remote moved.
+func conflicting(err error) bool {
+	return errorContains(err, "non-fast-forward") || errorContains(err, "failed to update ref")
+}
+
 func errorContains(err error, s string) bool {
 	if err == nil {
 		return false
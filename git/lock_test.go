@@ -0,0 +1,47 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/leaderelectiontest"
+)
+
+func TestLockConformance(t *testing.T) {
+	// local repositories are served by the git-upload-pack and git-receive-pack binaries
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	leaderelectiontest.RunLockConformance(t, func(t *testing.T, name, id string) le.Lock {
+		// one bare repository per record, shared by all the candidates
+		path := filepath.Join(dir, name)
+		if _, err := git.PlainInit(path, true); err != nil && err != git.ErrRepositoryAlreadyExists {
+			t.Fatal(err)
+		}
+		l, err := New(context.Background(), name, path, nil, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	})
+}
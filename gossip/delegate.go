@@ -17,11 +17,16 @@ package gossip
 import (
 	"bytes"
 	"context"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/hashicorp/memberlist"
-	"go.linka.cloud/grpc-toolkit/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
+
+	le "go.linka.cloud/leaderelection"
 )
 
 var _ memberlist.Delegate = (*delegate)(nil)
@@ -30,7 +35,7 @@ type delegate struct {
 	queue *memberlist.TransmitLimitedQueue
 	kmu   sync.RWMutex
 	kv    map[string]*kv
-	log   logger.Logger
+	log   logr.Logger
 
 	mmu  sync.RWMutex
 	meta []byte
@@ -40,7 +45,7 @@ func newDelegate(ctx context.Context, queue *memberlist.TransmitLimitedQueue) *d
 	return &delegate{
 		queue: queue,
 		kv:    make(map[string]*kv),
-		log:   logger.C(ctx),
+		log:   klog.FromContext(ctx),
 	}
 }
 
@@ -60,29 +65,29 @@ func (d *delegate) NodeMeta(s int) []byte {
 }
 
 func (d *delegate) NotifyMsg(b []byte) {
-	log := d.log.WithField("method", "delegate.NotifyMsg")
+	log := d.log.WithValues("method", "delegate.NotifyMsg")
 
 	a := &action{}
 	if err := a.Decode(b); err != nil {
 		panic(err)
 	}
-	log = log.WithField("key", a.key).WithField("type", a.typ)
+	log = log.WithValues("key", a.key, "type", a.typ)
 	d.kmu.Lock()
 	defer d.kmu.Unlock()
 	if v, ok := d.kv[a.key]; ok {
 		if a.time.Before(v.time) {
-			log.Tracef("skipping old message: %v | %v", a.time, v.time)
+			log.V(5).Info("skipping old message", "time", a.time, "current", v.time)
 			return
 		}
 		if bytes.Equal(v.value, a.value) {
 			if maybeClose(v.confirmed) {
-				log.Debugf("confirmed")
+				log.V(4).Info("confirmed")
 			}
 			return
 		}
-		log.Debugf("overriding value")
+		log.V(4).Info("overriding value")
 	}
-	log.Debugf("new message: %s", string(a.value))
+	log.V(4).Info("new message", "value", string(a.value))
 	switch a.typ {
 	case actionTypeSet:
 		k := &kv{key: a.key, value: a.value, confirmed: make(chan struct{}), time: a.time}
@@ -96,15 +101,14 @@ func (d *delegate) NotifyMsg(b []byte) {
 }
 
 func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte {
-	log := d.log.WithFields("method", "delegate.GetBroadcasts", "overhead", overhead, "limit", limit)
+	log := d.log.WithValues("method", "delegate.GetBroadcasts", "overhead", overhead, "limit", limit)
 	out := d.queue.GetBroadcasts(overhead, limit)
-	log.Tracef("length: %d", len(out))
+	log.V(5).Info("broadcasts", "length", len(out))
 	return out
 }
 
 func (d *delegate) LocalState(join bool) []byte {
-	log := d.log.WithFields("method", "delegate.LocalState", "join", join)
-	log.Trace()
+	d.log.V(5).Info("local state", "method", "delegate.LocalState", "join", join)
 	d.kmu.RLock()
 	defer d.kmu.RUnlock()
 	var b []byte
@@ -115,7 +119,7 @@ func (d *delegate) LocalState(join bool) []byte {
 }
 
 func (d *delegate) MergeRemoteState(buf []byte, join bool) {
-	log := d.log.WithFields("method", "delegate.MergeRemoteState", "join", join)
+	log := d.log.WithValues("method", "delegate.MergeRemoteState", "join", join)
 	d.kmu.Lock()
 	defer d.kmu.Unlock()
 	for len(buf) > 0 {
@@ -127,16 +131,16 @@ func (d *delegate) MergeRemoteState(buf []byte, join bool) {
 			panic(err)
 		}
 		buf = buf[n:]
-		log := log.WithField("key", k.key).WithField("value", string(k.value))
-		log.Trace()
+		log := log.WithValues("key", k.key, "value", string(k.value))
+		log.V(5).Info("remote state")
 		if v, ok := d.kv[k.key]; ok {
 			if k.time.Before(v.time) {
-				log.Tracef("skipping old message")
+				log.V(5).Info("skipping old message")
 				continue
 			}
 			if bytes.Equal(v.value, k.value) {
 				if maybeClose(v.confirmed) {
-					log.Debugf("confirmed: %s: %s", k.key, string(k.value))
+					log.V(4).Info("confirmed")
 				}
 				continue
 			}
@@ -147,8 +151,7 @@ func (d *delegate) MergeRemoteState(buf []byte, join bool) {
 }
 
 func (d *delegate) get(ctx context.Context, key string) ([]byte, bool, error) {
-	log := logger.C(ctx).WithFields("method", "delegate.get", "key", key)
-	log.Trace()
+	klog.FromContext(ctx).V(5).Info("get", "method", "delegate.get", "key", key)
 	d.kmu.RLock()
 	b, ok := d.kv[key]
 	d.kmu.RUnlock()
@@ -163,8 +166,26 @@ func (d *delegate) get(ctx context.Context, key string) ([]byte, bool, error) {
 	}
 }
 
-func (d *delegate) set(ctx context.Context, key string, value []byte) error {
-	log := logger.C(ctx).WithFields("method", "delegate.get", "key", key)
+// list returns the confirmed values of the keys starting with prefix.
+func (d *delegate) list(prefix string) map[string][]byte {
+	d.kmu.RLock()
+	defer d.kmu.RUnlock()
+	out := make(map[string][]byte)
+	for k, v := range d.kv {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		select {
+		case <-v.confirmed:
+			out[k] = v.value
+		default:
+		}
+	}
+	return out
+}
+
+func (d *delegate) set(ctx context.Context, key string, value []byte) (err error) {
+	log := klog.FromContext(ctx).WithValues("method", "delegate.set", "key", key)
 	d.kmu.Lock()
 	if kv, ok := d.kv[key]; ok && bytes.Equal(kv.value, value) {
 		defer d.kmu.Unlock()
@@ -175,16 +196,21 @@ func (d *delegate) set(ctx context.Context, key string, value []byte) error {
 			return ctx.Err()
 		}
 	}
-	log.Debugf("value: %s", string(value))
+	log.V(4).Info("set", "value", string(value))
 	k := &kv{key: key, value: value, confirmed: make(chan struct{}), time: time.Now().Truncate(time.Millisecond)}
 	d.kv[key] = k
 	d.kmu.Unlock()
+	// trace the time it takes for the broadcast to be confirmed
+	ctx, span := le.StartSpan(ctx, "gossip.broadcast", attribute.String("gossip.key", key), attribute.Int("gossip.nodes", d.queue.NumNodes()))
+	defer func() {
+		le.EndSpan(span, err)
+	}()
 	a := &action{typ: actionTypeSet, key: key, value: value, time: k.time}
 	b := a.Encode()
 	d.queue.QueueBroadcast(&broadcast{payload: b, action: a})
 	if d.queue.NumNodes() == 1 {
 		maybeClose(k.confirmed)
-		log.Debugf("single node: confirmed")
+		log.V(4).Info("single node: confirmed")
 	}
 	tk := time.NewTicker(5 * time.Millisecond)
 	for {
@@ -192,7 +218,7 @@ func (d *delegate) set(ctx context.Context, key string, value []byte) error {
 		case <-tk.C:
 			if d.queue.NumNodes() == 1 {
 				maybeClose(k.confirmed)
-				log.Debugf("single node: confirmed")
+				log.V(4).Info("single node: confirmed")
 			}
 		case <-k.confirmed:
 			return nil
@@ -5,23 +5,22 @@ go 1.20
 replace go.linka.cloud/leaderelection => ../
 
 require (
-	github.com/bombsimon/logrusr/v4 v4.0.0
 	github.com/efficientgo/core v1.0.0-rc.2
+	github.com/go-logr/logr v1.4.1
 	github.com/hashicorp/memberlist v0.5.0
 	github.com/miekg/dns v1.1.55
 	github.com/pkg/errors v0.9.1
-	github.com/sirupsen/logrus v1.9.3
-	go.linka.cloud/grpc-toolkit v0.4.3
 	go.linka.cloud/leaderelection v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.19.0
 	go.uber.org/multierr v1.11.0
-	golang.org/x/sys v0.10.0
+	golang.org/x/sys v0.13.0
 	k8s.io/klog/v2 v2.100.1
 )
 
 require (
 	github.com/armon/go-metrics v0.3.10 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/google/btree v1.0.1 // indirect
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
@@ -31,6 +30,8 @@ require (
 	github.com/hashicorp/go-sockaddr v1.0.2 // indirect
 	github.com/hashicorp/golang-lru v0.5.4 // indirect
 	github.com/sean-/seed v0.0.0-20170313163322-e2103e2c3529 // indirect
+	go.opentelemetry.io/otel/metric v1.19.0 // indirect
+	go.opentelemetry.io/otel/trace v1.19.0 // indirect
 	golang.org/x/mod v0.9.0 // indirect
 	golang.org/x/net v0.9.0 // indirect
 	golang.org/x/tools v0.7.0 // indirect
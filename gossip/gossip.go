@@ -21,8 +21,8 @@ import (
 	"time"
 
 	"github.com/hashicorp/memberlist"
-	"go.linka.cloud/grpc-toolkit/logger"
 	"go.uber.org/multierr"
+	"k8s.io/klog/v2"
 
 	le "go.linka.cloud/leaderelection"
 	"go.linka.cloud/leaderelection/gossip/internal/dns"
@@ -58,13 +58,15 @@ func (l *gossipLock) Close() error {
 	return l.kv.Close()
 }
 
+// New joins the memberlist cluster of addrs and returns a lock on lockName. The
+// lock logs with the logger of ctx (see klog.NewContext), which defaults to the
+// klog logger, until SetLogger replaces it.
 func New(ctx context.Context, config *memberlist.Config, lockName, id string, meta []byte, addrs ...string) (Lock, error) {
 	kv, err := newKVStore(ctx, config, meta, addrs...)
 	if err != nil {
 		return nil, err
 	}
-	lock := &lock{kv: kv, name: lockName, id: id}
-	return &gossipLock{kv: kv, lock: lock}, nil
+	return &gossipLock{kv: kv, lock: newLock(klog.FromContext(ctx), kv, lockName, id)}, nil
 }
 
 type kvstore struct {
@@ -112,7 +114,7 @@ func newKVStore(ctx context.Context, config *memberlist.Config, meta []byte, add
 }
 
 func (g *kvstore) Get(ctx context.Context, key string) ([]byte, error) {
-	logger.C(ctx).WithField("key", key).Tracef("gossip.Get")
+	klog.FromContext(ctx).V(5).Info("gossip.Get", "key", key)
 	b, ok, err := g.delegate.get(ctx, key)
 	if err != nil {
 		return nil, err
@@ -124,12 +126,17 @@ func (g *kvstore) Get(ctx context.Context, key string) ([]byte, error) {
 }
 
 func (g *kvstore) Set(ctx context.Context, key string, value []byte) error {
-	logger.C(ctx).WithField("key", key).Tracef("gossip.Set")
+	klog.FromContext(ctx).V(5).Info("gossip.Set", "key", key)
 	return g.delegate.set(ctx, key, value)
 }
 
+func (g *kvstore) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	klog.FromContext(ctx).V(5).Info("gossip.List", "prefix", prefix)
+	return g.delegate.list(prefix), nil
+}
+
 func (g *kvstore) Delete(ctx context.Context, key string) error {
-	logger.C(ctx).WithField("key", key).Tracef("gossip.Delete")
+	klog.FromContext(ctx).V(5).Info("gossip.Delete", "key", key)
 	return g.delegate.delete(ctx, key)
 }
 
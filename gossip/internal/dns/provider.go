@@ -9,8 +9,9 @@ import (
 	"strings"
 	"sync"
 
-	"go.linka.cloud/grpc-toolkit/logger"
+	"github.com/go-logr/logr"
 	"go.uber.org/multierr"
+	"k8s.io/klog/v2"
 
 	"go.linka.cloud/leaderelection/gossip/internal/dns/godns"
 	"go.linka.cloud/leaderelection/gossip/internal/dns/miekgdns"
@@ -22,7 +23,7 @@ type Provider struct {
 	resolver Resolver
 	// A map from domain name to a slice of resolved targets.
 	resolved map[string][]string
-	logger   logger.Logger
+	logger   logr.Logger
 }
 
 type ResolverType string
@@ -40,7 +41,7 @@ func (t ResolverType) ToResolver(ctx context.Context) ipLookupResolver {
 	case MiekgdnsResolverType:
 		r = &miekgdns.Resolver{ResolvConf: miekgdns.DefaultResolvConfPath}
 	default:
-		logger.C(ctx).WithField("type", t).Warn("no such resolver type, defaulting to golang")
+		klog.FromContext(ctx).Info("no such resolver type, defaulting to golang", "type", t)
 		r = &godns.Resolver{Resolver: net.DefaultResolver}
 	}
 	return r
@@ -52,7 +53,7 @@ func NewProvider(ctx context.Context, resolverType ResolverType) *Provider {
 	p := &Provider{
 		resolver: NewResolver(ctx, resolverType.ToResolver(ctx)),
 		resolved: make(map[string][]string),
-		logger:   logger.C(ctx),
+		logger:   klog.FromContext(ctx),
 	}
 
 	return p
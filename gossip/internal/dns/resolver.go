@@ -9,7 +9,8 @@ import (
 	"strconv"
 	"strings"
 
-	"go.linka.cloud/grpc-toolkit/logger"
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
 
 	"github.com/pkg/errors"
 )
@@ -41,12 +42,12 @@ type ipLookupResolver interface {
 
 type dnsSD struct {
 	resolver ipLookupResolver
-	logger   logger.Logger
+	logger   logr.Logger
 }
 
 // NewResolver creates a resolver with given underlying resolver.
 func NewResolver(ctx context.Context, resolver ipLookupResolver) Resolver {
-	return &dnsSD{resolver: resolver, logger: logger.C(ctx)}
+	return &dnsSD{resolver: resolver, logger: klog.FromContext(ctx)}
 }
 
 func (s *dnsSD) Resolve(ctx context.Context, name string, qtype QType) ([]string, error) {
@@ -82,7 +83,7 @@ func (s *dnsSD) Resolve(ctx context.Context, name string, qtype QType) ([]string
 				return nil, errors.Wrapf(err, "lookup IP addresses %q", host)
 			}
 			if ips == nil {
-				s.logger.WithField("host", host).WithError(err).Warn("failed to lookup IP addresses")
+				s.logger.Info("failed to lookup IP addresses", "host", host, "error", err)
 			}
 		}
 		for _, ip := range ips {
@@ -95,7 +96,7 @@ func (s *dnsSD) Resolve(ctx context.Context, name string, qtype QType) ([]string
 				return nil, errors.Wrapf(err, "lookup SRV records %q", host)
 			}
 			if len(recs) == 0 {
-				s.logger.WithField("host", host).WithError(err).Warn("failed to lookup SRV records")
+				s.logger.Info("failed to lookup SRV records", "host", host, "error", err)
 			}
 		}
 
@@ -117,7 +118,7 @@ func (s *dnsSD) Resolve(ctx context.Context, name string, qtype QType) ([]string
 					return nil, errors.Wrapf(err, "lookup IP addresses %q", host)
 				}
 				if len(resIPs) == 0 {
-					s.logger.WithField("srv", host).WithField("a", rec.Target).WithError(err).Warn("failed to lookup IP addresses")
+					s.logger.Info("failed to lookup IP addresses", "srv", host, "a", rec.Target, "error", err)
 				}
 			}
 			for _, resIP := range resIPs {
@@ -129,7 +130,7 @@ func (s *dnsSD) Resolve(ctx context.Context, name string, qtype QType) ([]string
 	}
 
 	if res == nil && err == nil {
-		s.logger.WithField("host", host).Warn("IP address lookup yielded no results. No host found or no addresses found")
+		s.logger.Info("IP address lookup yielded no results. No host found or no addresses found", "host", host)
 	}
 
 	return res, nil
@@ -9,7 +9,7 @@ import (
 	"sort"
 	"testing"
 
-	"go.linka.cloud/grpc-toolkit/logger"
+	"k8s.io/klog/v2"
 
 	"github.com/pkg/errors"
 
@@ -191,7 +191,7 @@ func TestDnsSD_Resolve(t *testing.T) {
 
 func testDnsSd(t *testing.T, tt DNSSDTest) {
 	ctx := context.TODO()
-	dnsSD := dnsSD{tt.resolver, logger.C(ctx)}
+	dnsSD := dnsSD{tt.resolver, klog.FromContext(ctx)}
 
 	result, err := dnsSD.Resolve(ctx, tt.addr, tt.qtype)
 	if tt.expectedErr != nil {
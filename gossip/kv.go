@@ -30,6 +30,13 @@ type KV interface {
 	Close() error
 }
 
+// Lister is implemented by the KV stores able to list their keys, which the
+// locks need to keep track of the election candidates.
+type Lister interface {
+	// List returns the values of the keys starting with prefix.
+	List(ctx context.Context, prefix string) (map[string][]byte, error)
+}
+
 type kv struct {
 	key       string
 	time      time.Time
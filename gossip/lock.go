@@ -16,68 +16,149 @@ package gossip
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 
-	"go.linka.cloud/grpc-toolkit/logger"
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
 
 	le "go.linka.cloud/leaderelection"
 )
 
-var _ le.Lock = (*lock)(nil)
+var (
+	_ le.Lock              = (*lock)(nil)
+	_ le.CandidateRegistry = (*lock)(nil)
+	_ le.LoggerSetter      = (*lock)(nil)
+	_ Lister               = (*kvstore)(nil)
+)
 
 type lock struct {
 	kv   KV
 	name string
 	id   string
+	log  logr.Logger
 }
 
+// NewLock returns a lock on the key name of kv. The lock logs with the klog
+// logger until SetLogger replaces it.
 func NewLock(kv KV, name string, id string) le.Lock {
+	return newLock(klog.Background(), kv, name, id)
+}
+
+func newLock(log logr.Logger, kv KV, name string, id string) *lock {
 	return &lock{
 		kv:   kv,
 		name: name,
 		id:   id,
+		log:  log.WithValues("lock", name, "identity", id),
 	}
 }
 
-func (l *lock) Get(ctx context.Context) (*le.Record, []byte, error) {
-	logger.C(ctx).Tracef("lock.Get")
+func (l *lock) Get(ctx context.Context) (*le.Record, []byte, le.Version, error) {
+	klog.FromContext(ctx).V(5).Info("lock.Get")
 	b, err := l.kv.Get(ctx, l.name)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
 	ler := &le.Record{}
 	if err := json.Unmarshal(b, ler); err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
-	return ler, b, nil
+	return ler, b, version(b), nil
+}
+
+func (l *lock) Create(ctx context.Context, ler le.Record) (le.Version, error) {
+	klog.FromContext(ctx).V(5).Info("lock.Create")
+	return l.set(ctx, ler, "")
+}
+
+func (l *lock) Update(ctx context.Context, ler le.Record, version le.Version) (le.Version, error) {
+	klog.FromContext(ctx).V(5).Info("lock.Update")
+	return l.set(ctx, ler, version)
+}
+
+// Consistency returns le.BestEffort: the store is last-writer-wins, the version
+// can only be checked against the local replica.
+func (l *lock) Consistency() le.Consistency {
+	return le.BestEffort
 }
 
-func (l *lock) Create(ctx context.Context, ler le.Record) error {
-	logger.C(ctx).Tracef("lock.Create")
+func (l *lock) set(ctx context.Context, ler le.Record, v le.Version) (le.Version, error) {
+	cur, _, curv, err := l.Get(ctx)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if curv != v {
+		return "", &le.ConflictError{Lock: l.Describe(), Version: v}
+	}
+	// at least refuse to hand out a fencing token that is not greater than
+	// the one of the term we know about
+	if cur != nil && cur.HolderIdentity != ler.HolderIdentity && cur.LeaderTransitions >= ler.LeaderTransitions {
+		return "", &le.ConflictError{Lock: l.Describe(), Version: v, Err: fmt.Errorf("stale leader transitions: %d <= %d", ler.LeaderTransitions, cur.LeaderTransitions)}
+	}
 	b, err := json.Marshal(ler)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if err := l.kv.Set(ctx, l.name, b); err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return version(b), nil
 }
 
-func (l *lock) Update(ctx context.Context, ler le.Record) error {
-	logger.C(ctx).Tracef("lock.Update")
-	b, err := json.Marshal(ler)
+// Register sets the candidate entry of the lock identity in the KV store.
+func (l *lock) Register(ctx context.Context, c le.Candidate) error {
+	b, err := json.Marshal(c)
 	if err != nil {
 		return err
 	}
-	if err := l.kv.Set(ctx, l.name, b); err != nil {
-		return err
+	return l.kv.Set(ctx, l.candidateKey(l.id), b)
+}
+
+// Unregister deletes the candidate entry of the lock identity. As the deletions
+// are not gossiped to the members joining later, the entry may reappear until
+// it is considered stale.
+func (l *lock) Unregister(ctx context.Context) error {
+	return l.kv.Delete(ctx, l.candidateKey(l.id))
+}
+
+// Candidates returns the candidate entries known to the member. The KV store
+// must implement Lister.
+func (l *lock) Candidates(ctx context.Context) ([]le.Candidate, error) {
+	ls, ok := l.kv.(Lister)
+	if !ok {
+		return nil, le.ErrUnsupported
+	}
+	m, err := ls.List(ctx, l.candidateKey(""))
+	if err != nil {
+		return nil, err
+	}
+	var cs []le.Candidate
+	for k, b := range m {
+		var c le.Candidate
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		cs = append(cs, c)
 	}
-	return nil
+	return cs, nil
 }
 
-func (l *lock) RecordEvent(_ string) {}
+func (l *lock) candidateKey(id string) string {
+	return l.name + "/candidates/" + id
+}
+
+func (l *lock) RecordEvent(m string) {
+	l.log.Info("lock event", "event", m)
+}
+
+// SetLogger replaces the logger of the lock, see le.LoggerSetter.
+func (l *lock) SetLogger(log logr.Logger) {
+	l.log = log
+}
 
 func (l *lock) Identity() string {
 	return l.id
@@ -86,3 +167,9 @@ func (l *lock) Identity() string {
 func (l *lock) Describe() string {
 	return fmt.Sprintf("gossip/%s", l.name)
 }
+
+// version returns the version of a raw record: the KV does not expose any
+// revision, but the record changes on every write.
+func version(b []byte) le.Version {
+	return le.Version(fmt.Sprintf("%x", sha256.Sum256(b)))
+}
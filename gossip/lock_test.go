@@ -0,0 +1,42 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gossip
+
+import (
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/hashicorp/memberlist"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/leaderelectiontest"
+)
+
+func TestLockConformance(t *testing.T) {
+	// a single local node: all the candidates share its store
+	config := memberlist.DefaultLocalConfig()
+	config.Name = "conformance"
+	config.BindAddr = "127.0.0.1"
+	config.BindPort = 0
+	kv, err := NewKVStore(context.Background(), config, nil)
+	testutil.Ok(t, err)
+	t.Cleanup(func() {
+		testutil.Ok(t, kv.Close())
+	})
+	leaderelectiontest.RunLockConformance(t, func(t *testing.T, name, id string) le.Lock {
+		return NewLock(kv, name, id)
+	})
+}
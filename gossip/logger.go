@@ -19,37 +19,34 @@ import (
 	"context"
 	"log"
 
-	"github.com/bombsimon/logrusr/v4"
-	"github.com/sirupsen/logrus"
-	"go.linka.cloud/grpc-toolkit/logger"
+	"github.com/go-logr/logr"
 	"k8s.io/klog/v2"
 )
 
+// newLogger returns a standard logger for memberlist writing to the logger
+// of ctx, mapping its [DEBUG], [INFO], [WARN] and [ERROR] prefixes to levels.
 func newLogger(ctx context.Context) *log.Logger {
-	return log.New(&logw{logger: logger.C(ctx)}, "", 0)
+	return log.New(&logw{logger: klog.FromContext(ctx)}, "", 0)
 }
 
 type logw struct {
-	logger logger.Logger
+	logger logr.Logger
 }
 
 func (l *logw) Write(b []byte) (int, error) {
+	n := len(b)
 	b = bytes.TrimRight(b, "\n")
 	switch {
 	case bytes.HasPrefix(b, []byte("[DEBUG]")):
-		l.logger.Debug(string(bytes.TrimPrefix(b, []byte("[DEBUG] "))))
+		l.logger.V(4).Info(string(bytes.TrimPrefix(b, []byte("[DEBUG] "))))
 	case bytes.HasPrefix(b, []byte("[INFO]")):
 		l.logger.Info(string(bytes.TrimPrefix(b, []byte("[INFO] "))))
 	case bytes.HasPrefix(b, []byte("[WARN]")):
-		l.logger.Warn(string(bytes.TrimPrefix(b, []byte("[WARN] "))))
+		l.logger.Info(string(bytes.TrimPrefix(b, []byte("[WARN] "))))
 	case bytes.HasPrefix(b, []byte("[ERROR]")):
-		l.logger.Error(string(bytes.TrimPrefix(b, []byte("[ERROR] "))))
+		l.logger.Error(nil, string(bytes.TrimPrefix(b, []byte("[ERROR] "))))
 	default:
 		l.logger.Info(string(b))
 	}
-	return len(b), nil
-}
-
-func init() {
-	klog.SetLogger(logrusr.New(logrus.StandardLogger()))
+	return n, nil
 }
@@ -17,6 +17,8 @@ limitations under the License.
 package leaderelection
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"sync"
 	"time"
@@ -30,9 +32,10 @@ import (
 // has failed to renew without exiting the process. In that case we should
 // report not healthy and rely on the kubelet to take down the process.
 type HealthzAdaptor struct {
-	pointerLock sync.Mutex
-	le          *LeaderElector
-	timeout     time.Duration
+	pointerLock    sync.Mutex
+	le             *LeaderElector
+	timeout        time.Duration
+	maxUnreachable time.Duration
 }
 
 // Name returns the name of the health check we are implementing.
@@ -51,6 +54,31 @@ func (l *HealthzAdaptor) Check(req *http.Request) error {
 	return l.le.Check(l.timeout)
 }
 
+// ReadyCheck is called by the readyz endpoint handler of the services only
+// the leader serves. It fails if we do not own the lease, or own it but had
+// not been able to renew it.
+func (l *HealthzAdaptor) ReadyCheck(req *http.Request) error {
+	l.pointerLock.Lock()
+	defer l.pointerLock.Unlock()
+	if l.le == nil {
+		return errors.New("leader election not started")
+	}
+	return l.le.CheckLeader(l.timeout)
+}
+
+// FollowerCheck is called by the healthz endpoint handler.
+// It fails if we do not own the lease and the lock has been unreachable for
+// longer than the maxUnreachable given to NewHealthzAdaptor. It never fails
+// if maxUnreachable is 0.
+func (l *HealthzAdaptor) FollowerCheck(req *http.Request) error {
+	l.pointerLock.Lock()
+	defer l.pointerLock.Unlock()
+	if l.le == nil || l.maxUnreachable == 0 {
+		return nil
+	}
+	return l.le.CheckFollower(l.maxUnreachable)
+}
+
 // SetLeaderElection ties a leader election object to a HealthzAdaptor
 func (l *HealthzAdaptor) SetLeaderElection(le *LeaderElector) {
 	l.pointerLock.Lock()
@@ -58,12 +86,89 @@ func (l *HealthzAdaptor) SetLeaderElection(le *LeaderElector) {
 	l.le = le
 }
 
+// LivezHandler returns the handler of the /livez endpoint, running Check.
+func (l *HealthzAdaptor) LivezHandler() http.Handler {
+	return l.handler(healthCheck{l.Name(), l.Check})
+}
+
+// HealthzHandler returns the handler of the /healthz endpoint, running Check
+// and FollowerCheck.
+func (l *HealthzAdaptor) HealthzHandler() http.Handler {
+	return l.handler(healthCheck{l.Name(), l.Check}, healthCheck{"follower", l.FollowerCheck})
+}
+
+// ReadyzHandler returns the handler of the /readyz endpoint, running ReadyCheck.
+func (l *HealthzAdaptor) ReadyzHandler() http.Handler {
+	return l.handler(healthCheck{"leader", l.ReadyCheck})
+}
+
+type healthCheck struct {
+	name  string
+	check func(req *http.Request) error
+}
+
+// HealthzStatus is the JSON body written by the handlers of the HealthzAdaptor.
+type HealthzStatus struct {
+	// Status is "ok" if all the checks passed, else "failed".
+	Status string `json:"status"`
+	// Name is the name of the lease, Identity the identity of the client and
+	// Leader the last observed leader, once the LeaderElector is set.
+	Name     string `json:"name,omitempty"`
+	Identity string `json:"identity,omitempty"`
+	Leader   string `json:"leader,omitempty"`
+	// Checks are the results of the checks, in order.
+	Checks []HealthzCheckStatus `json:"checks"`
+}
+
+// HealthzCheckStatus is the result of a check.
+type HealthzCheckStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handler returns the handler running the checks, which answers with a 503
+// Service Unavailable status if any of them failed.
+func (l *HealthzAdaptor) handler(checks ...healthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := HealthzStatus{Status: "ok"}
+		l.pointerLock.Lock()
+		if le := l.le; le != nil {
+			s.Name, s.Identity, s.Leader = le.config.Name, le.config.Lock.Identity(), le.GetLeader()
+		}
+		l.pointerLock.Unlock()
+		for _, c := range checks {
+			cs := HealthzCheckStatus{Name: c.name, Status: "ok"}
+			if err := c.check(r); err != nil {
+				s.Status, cs.Status, cs.Error = "failed", "failed", err.Error()
+			}
+			s.Checks = append(s.Checks, cs)
+		}
+		code := http.StatusOK
+		if s.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(s)
+	})
+}
+
 // NewLeaderHealthzAdaptor creates a basic healthz adaptor to monitor a leader election.
 // timeout determines the time beyond the lease expiry to be allowed for timeout.
 // checks within the timeout period after the lease expires will still return healthy.
 func NewLeaderHealthzAdaptor(timeout time.Duration) *HealthzAdaptor {
+	return NewHealthzAdaptor(timeout, 0)
+}
+
+// NewHealthzAdaptor creates a healthz adaptor to monitor a leader election,
+// whose FollowerCheck fails once the lock has been unreachable for longer
+// than maxUnreachable. timeout is the same as in NewLeaderHealthzAdaptor.
+func NewHealthzAdaptor(timeout, maxUnreachable time.Duration) *HealthzAdaptor {
 	result := &HealthzAdaptor{
-		timeout: timeout,
+		timeout:        timeout,
+		maxUnreachable: maxUnreachable,
 	}
 	return result
 }
@@ -18,13 +18,26 @@ package leaderelection
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
 )
 
 // Record is the record that is stored in the leader election annotation.
 // This information should be used for observational purposes only and could be replaced
 // with a random string (e.g. UUID) with only slight modification of this code.
-// TODO(mikedanese): this should potentially be versioned
+//
+// The Record is versioned, see RecordVersion: its JSON encoding keeps the
+// fields it does not know, so that the records written by newer versions of
+// the package survive the updates of the older ones.
 type Record struct {
+	// Version is the version of the schema of the record, RecordVersion when
+	// written by this package, unless it updates a record of a newer version,
+	// and 0 for the records written before it was versioned.
+	Version int `json:"version,omitempty"`
 	// HolderIdentity is the ID that owns the lease. If empty, no one owns this lease and
 	// all callers may acquire. Versions of this library prior to Kubernetes 1.14 will not
 	// attempt to acquire leases with empty identities and will wait for the full lease
@@ -35,6 +48,88 @@ type Record struct {
 	AcquireTime               int64  `json:"acquireTime"`
 	RenewTime                 int64  `json:"renewTime"`
 	LeaderTransitions         int    `json:"leaderTransitions"`
+	// HolderPriority is the priority of the holder, see Config.Priority.
+	HolderPriority int `json:"holderPriority,omitempty"`
+	// HolderAddress is the address advertised by the holder, e.g. the URL
+	// of its API, see Config.Address.
+	HolderAddress string `json:"holderAddress,omitempty"`
+	// HolderMetadata is the metadata published by the holder, see Config.Metadata.
+	HolderMetadata map[string]string `json:"holderMetadata,omitempty"`
+	// TransferTo is the identity of the successor the holder is handing the lease
+	// over to (see LeaderElector.Transfer). Until the lease expires, only this
+	// identity may acquire it.
+	TransferTo string `json:"transferTo,omitempty"`
+	// Challenger is the identity of the candidate with the highest priority that
+	// asked the holder to yield, and ChallengerPriority its priority.
+	Challenger         string `json:"challenger,omitempty"`
+	ChallengerPriority int    `json:"challengerPriority,omitempty"`
+
+	// unknown holds the fields of the JSON encoding of the record this
+	// version of the package does not know.
+	unknown map[string]json.RawMessage
+}
+
+// Version is an opaque identifier of the stored revision of a Record,
+// e.g. a resourceVersion, an ETag or a commit hash. It is returned by Lock.Get
+// and must be passed back to Lock.Update.
+type Version string
+
+// Consistency describes the concurrency control a Lock provides on Update.
+type Consistency int
+
+const (
+	// BestEffort locks check the version before writing the Record, but cannot
+	// do it atomically: two concurrent updates may both succeed, the last
+	// one winning.
+	BestEffort Consistency = iota
+	// CompareAndSwap locks atomically reject an update if the stored version
+	// moved since it was read.
+	CompareAndSwap
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case BestEffort:
+		return "best-effort"
+	case CompareAndSwap:
+		return "compare-and-swap"
+	default:
+		return fmt.Sprintf("Consistency(%d)", int(c))
+	}
+}
+
+// ErrConflict is matched by the errors returned by a Lock when the stored
+// Record changed concurrently.
+var ErrConflict = errors.New("record changed concurrently")
+
+// ConflictError is returned by Lock.Create when the Record already exists,
+// and by Lock.Update when the stored version is not the expected one.
+type ConflictError struct {
+	// Lock is the description of the lock
+	Lock string
+	// Version is the version the caller expected
+	Version Version
+	// Err is the optional underlying backend error
+	Err error
+}
+
+func (e *ConflictError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Lock, ErrConflict)
+	if e.Version != "" {
+		msg = fmt.Sprintf("%s: expected version %s", msg, e.Version)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
 }
 
 // Lock offers a common interface for locking on arbitrary
@@ -43,14 +138,21 @@ type Record struct {
 // them to change over time.  This interface is strictly for use
 // by the leaderelection code.
 type Lock interface {
-	// Get returns the Record
-	Get(ctx context.Context) (*Record, []byte, error)
+	// Get returns the Record, its raw representation and its version.
+	// It returns an error matching os.ErrNotExist if there is no Record yet.
+	Get(ctx context.Context) (*Record, []byte, Version, error)
+
+	// Create attempts to create a Record and returns its version.
+	// It returns a *ConflictError if the Record already exists.
+	Create(ctx context.Context, ler Record) (Version, error)
 
-	// Create attempts to create a Record
-	Create(ctx context.Context, ler Record) error
+	// Update will update and existing Record if its stored version is still
+	// version, and returns the new version.
+	// It returns a *ConflictError if the stored version moved.
+	Update(ctx context.Context, ler Record, version Version) (Version, error)
 
-	// Update will update and existing Record
-	Update(ctx context.Context, ler Record) error
+	// Consistency declares whether Update is a true compare-and-swap
+	Consistency() Consistency
 
 	// RecordEvent is used to record events
 	RecordEvent(string)
@@ -62,3 +164,34 @@ type Lock interface {
 	// into a string
 	Describe() string
 }
+
+// Candidate is a participant of the election, registered by its LeaderElector.
+type Candidate struct {
+	// Identity is the identity of the candidate Lock.
+	Identity string `json:"identity"`
+	// Metadata is the metadata published by the candidate, see Config.Metadata.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// LastSeen is the time of the last heartbeat of the candidate, as measured
+	// by its own clock.
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// CandidateRegistry is the optional Lock extension keeping track of the
+// candidates of the election: each candidate holds an entry next to the Record,
+// which its LeaderElector refreshes while running.
+type CandidateRegistry interface {
+	// Register creates or refreshes the entry of the Lock identity.
+	Register(ctx context.Context, c Candidate) error
+	// Unregister removes the entry of the Lock identity, if any.
+	Unregister(ctx context.Context) error
+	// Candidates returns the registered candidates, in any order.
+	Candidates(ctx context.Context) ([]Candidate, error)
+}
+
+// LoggerSetter is the optional Lock extension logging outside of its
+// operations, e.g. its events: the operations log with the logger of their
+// context. New sets it to the Config.Logger, if any.
+type LoggerSetter interface {
+	// SetLogger replaces the logger of the Lock.
+	SetLogger(l logr.Logger)
+}
@@ -18,12 +18,17 @@ package k8s
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
@@ -32,10 +37,40 @@ import (
 	clientset "k8s.io/client-go/kubernetes"
 	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
 
 	le "go.linka.cloud/leaderelection"
 )
 
+// The Lease annotations storing the le.Record fields without Lease spec counterpart.
+// The annotations of newer versions of the record are kept by Update, which
+// only sets these.
+const (
+	RecordVersionAnnotation      = "leaderelection.linka.cloud/record-version"
+	HolderPriorityAnnotation     = "leaderelection.linka.cloud/holder-priority"
+	TransferToAnnotation         = "leaderelection.linka.cloud/transfer-to"
+	ChallengerAnnotation         = "leaderelection.linka.cloud/challenger"
+	ChallengerPriorityAnnotation = "leaderelection.linka.cloud/challenger-priority"
+	HolderAddressAnnotation      = "leaderelection.linka.cloud/holder-address"
+	// HolderMetadataAnnotation stores the JSON encoded le.Record.HolderMetadata.
+	HolderMetadataAnnotation = "leaderelection.linka.cloud/holder-metadata"
+)
+
+const (
+	// CandidateOfLabel is the label of the candidate Leases, holding the name
+	// of the election Lease. The candidates of an election are listed
+	// using it, so the election Lease name must be a valid label value.
+	CandidateOfLabel = "leaderelection.linka.cloud/candidate-of"
+	// MetadataAnnotation is the candidate Lease annotation storing the
+	// JSON encoded le.Candidate.Metadata.
+	MetadataAnnotation = "leaderelection.linka.cloud/metadata"
+)
+
+var (
+	_ le.CandidateRegistry = (*LeaseLock)(nil)
+	_ le.LoggerSetter      = (*LeaseLock)(nil)
+)
+
 // EventRecorder records a change in the ResourceLock.
 type EventRecorder interface {
 	Eventf(obj runtime.Object, eventType, reason, message string, args ...interface{})
@@ -49,6 +84,9 @@ type Config struct {
 	Identity string
 	// EventRecorder is optional.
 	EventRecorder EventRecorder
+	// Logger logs the events when there is no EventRecorder. It defaults to
+	// the klog logger, see also LeaseLock.SetLogger.
+	Logger logr.Logger
 }
 
 // New will create a lock of a given type according to the input parameters
@@ -89,62 +127,114 @@ type LeaseLock struct {
 }
 
 // Get returns the election record from a Lease spec
-func (ll *LeaseLock) Get(ctx context.Context) (*le.Record, []byte, error) {
+func (ll *LeaseLock) Get(ctx context.Context) (_ *le.Record, _ []byte, _ le.Version, err error) {
+	ctx, span := ll.startSpan(ctx, "k8s.Leases.Get")
+	defer func() {
+		le.EndSpan(span, err)
+	}()
 	lease, err := ll.Client.Leases(ll.LeaseMeta.Namespace).Get(ctx, ll.LeaseMeta.Name, metav1.GetOptions{})
 	if err != nil {
 		if kerrors.IsNotFound(err) {
-			return nil, nil, os.ErrNotExist
+			return nil, nil, "", os.ErrNotExist
 		}
-		return nil, nil, err
+		return nil, nil, "", err
 	}
 	ll.lease = lease
-	record := LeaseSpecToLeaderElectionRecord(&ll.lease.Spec)
+	record := leaseToRecord(ll.lease)
 	recordByte, err := json.Marshal(*record)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
-	return record, recordByte, nil
+	return record, recordByte, le.Version(lease.ResourceVersion), nil
 }
 
 // Create attempts to create a Lease
-func (ll *LeaseLock) Create(ctx context.Context, ler le.Record) error {
-	var err error
-	ll.lease, err = ll.Client.Leases(ll.LeaseMeta.Namespace).Create(ctx, &coordinationv1.Lease{
+func (ll *LeaseLock) Create(ctx context.Context, ler le.Record) (_ le.Version, err error) {
+	ctx, span := ll.startSpan(ctx, "k8s.Leases.Create")
+	defer func() {
+		le.EndSpan(span, err)
+	}()
+	lease := &coordinationv1.Lease{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      ll.LeaseMeta.Name,
 			Namespace: ll.LeaseMeta.Namespace,
 		},
-		Spec: LeaderElectionRecordToLeaseSpec(&ler),
-	}, metav1.CreateOptions{})
-	return err
+	}
+	setRecord(lease, &ler)
+	lease, err = ll.Client.Leases(ll.LeaseMeta.Namespace).Create(ctx, lease, metav1.CreateOptions{})
+	if err != nil {
+		if kerrors.IsAlreadyExists(err) {
+			return "", &le.ConflictError{Lock: ll.Describe(), Err: err}
+		}
+		return "", err
+	}
+	ll.lease = lease
+	return le.Version(lease.ResourceVersion), nil
 }
 
-// Update will update an existing Lease spec.
-func (ll *LeaseLock) Update(ctx context.Context, ler le.Record) error {
+// Update will update an existing Lease spec if its resourceVersion is still version.
+func (ll *LeaseLock) Update(ctx context.Context, ler le.Record, version le.Version) (_ le.Version, err error) {
+	ctx, span := ll.startSpan(ctx, "k8s.Leases.Update")
+	defer func() {
+		le.EndSpan(span, err)
+	}()
 	if ll.lease == nil {
-		return errors.New("lease not initialized, call get or create first")
+		return "", errors.New("lease not initialized, call get or create first")
 	}
-	ll.lease.Spec = LeaderElectionRecordToLeaseSpec(&ler)
+	lease := ll.lease.DeepCopy()
+	lease.ResourceVersion = string(version)
+	setRecord(lease, &ler)
 
-	lease, err := ll.Client.Leases(ll.LeaseMeta.Namespace).Update(ctx, ll.lease, metav1.UpdateOptions{})
+	lease, err = ll.Client.Leases(ll.LeaseMeta.Namespace).Update(ctx, lease, metav1.UpdateOptions{})
 	if err != nil {
 		if kerrors.IsNotFound(err) {
-			return os.ErrNotExist
+			return "", os.ErrNotExist
 		}
-		return err
+		if kerrors.IsConflict(err) {
+			return "", &le.ConflictError{Lock: ll.Describe(), Version: version, Err: err}
+		}
+		return "", err
 	}
 
 	ll.lease = lease
-	return nil
+	return le.Version(lease.ResourceVersion), nil
+}
+
+// startSpan starts the span of a call to the API server.
+func (ll *LeaseLock) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return le.StartSpan(ctx, name,
+		attribute.String("k8s.namespace.name", ll.LeaseMeta.Namespace),
+		attribute.String("k8s.lease.name", ll.LeaseMeta.Name),
+	)
+}
+
+// Consistency returns le.CompareAndSwap: the API server rejects updates
+// with a stale resourceVersion.
+func (ll *LeaseLock) Consistency() le.Consistency {
+	return le.CompareAndSwap
+}
+
+// SetLogger replaces the logger of the lock config, see le.LoggerSetter.
+func (ll *LeaseLock) SetLogger(log logr.Logger) {
+	ll.LockConfig.Logger = log
 }
 
 // RecordEvent in leader election while adding meta-data
 func (ll *LeaseLock) RecordEvent(s string) {
 	if ll.LockConfig.EventRecorder == nil {
+		log := ll.LockConfig.Logger
+		if log.GetSink() == nil {
+			log = klog.Background()
+		}
+		log.Info("lock event", "lock", ll.Describe(), "identity", ll.LockConfig.Identity, "event", s)
 		return
 	}
 	events := fmt.Sprintf("%v %v", ll.LockConfig.Identity, s)
-	subject := &coordinationv1.Lease{ObjectMeta: ll.lease.ObjectMeta}
+	meta := ll.LeaseMeta
+	if ll.lease != nil {
+		meta = ll.lease.ObjectMeta
+	}
+	subject := &coordinationv1.Lease{ObjectMeta: meta}
 	// Populate the type meta, so we don't have to get it from the schema
 	subject.Kind = "Lease"
 	subject.APIVersion = coordinationv1.SchemeGroupVersion.String()
@@ -162,6 +252,68 @@ func (ll *LeaseLock) Identity() string {
 	return ll.LockConfig.Identity
 }
 
+// Register creates or refreshes the candidate Lease of the lock identity.
+func (ll *LeaseLock) Register(ctx context.Context, c le.Candidate) error {
+	leases := ll.Client.Leases(ll.LeaseMeta.Namespace)
+	lease, err := leases.Get(ctx, ll.candidateName(), metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	if kerrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ll.candidateName(),
+				Namespace: ll.LeaseMeta.Namespace,
+				Labels:    map[string]string{CandidateOfLabel: ll.LeaseMeta.Name},
+			},
+		}
+	}
+	if err := setCandidate(lease, c); err != nil {
+		return err
+	}
+	if lease.ResourceVersion == "" {
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+	} else {
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// Unregister deletes the candidate Lease of the lock identity.
+func (ll *LeaseLock) Unregister(ctx context.Context) error {
+	err := ll.Client.Leases(ll.LeaseMeta.Namespace).Delete(ctx, ll.candidateName(), metav1.DeleteOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Candidates lists the candidate Leases of the election.
+func (ll *LeaseLock) Candidates(ctx context.Context) ([]le.Candidate, error) {
+	list, err := ll.Client.Leases(ll.LeaseMeta.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", CandidateOfLabel, ll.LeaseMeta.Name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var cs []le.Candidate
+	for i := range list.Items {
+		c, err := leaseToCandidate(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// candidateName returns the name of the candidate Lease of the lock identity.
+// The identity is hashed as it may not be a valid object name.
+func (ll *LeaseLock) candidateName() string {
+	h := sha256.Sum256([]byte(ll.LockConfig.Identity))
+	return fmt.Sprintf("%s-candidate-%x", ll.LeaseMeta.Name, h[:8])
+}
+
 func LeaseSpecToLeaderElectionRecord(spec *coordinationv1.LeaseSpec) *le.Record {
 	var r le.Record
 	if spec.HolderIdentity != nil {
@@ -197,3 +349,87 @@ func LeaderElectionRecordToLeaseSpec(ler *le.Record) coordinationv1.LeaseSpec {
 		LeaseTransitions:     &leaseTransitions,
 	}
 }
+
+// leaseToRecord returns the election record stored in the lease spec and annotations.
+func leaseToRecord(lease *coordinationv1.Lease) *le.Record {
+	r := LeaseSpecToLeaderElectionRecord(&lease.Spec)
+	r.Version, _ = strconv.Atoi(lease.Annotations[RecordVersionAnnotation])
+	r.HolderPriority, _ = strconv.Atoi(lease.Annotations[HolderPriorityAnnotation])
+	r.TransferTo = lease.Annotations[TransferToAnnotation]
+	r.Challenger = lease.Annotations[ChallengerAnnotation]
+	r.ChallengerPriority, _ = strconv.Atoi(lease.Annotations[ChallengerPriorityAnnotation])
+	r.HolderAddress = lease.Annotations[HolderAddressAnnotation]
+	if md, ok := lease.Annotations[HolderMetadataAnnotation]; ok {
+		// the record of a holder which stored invalid metadata is still valid
+		_ = json.Unmarshal([]byte(md), &r.HolderMetadata)
+	}
+	return r
+}
+
+// setRecord stores the election record in the lease spec and annotations.
+func setRecord(lease *coordinationv1.Lease, ler *le.Record) {
+	lease.Spec = LeaderElectionRecordToLeaseSpec(ler)
+	setAnnotation(&lease.ObjectMeta, RecordVersionAnnotation, itoa(ler.Version))
+	setAnnotation(&lease.ObjectMeta, HolderPriorityAnnotation, itoa(ler.HolderPriority))
+	setAnnotation(&lease.ObjectMeta, TransferToAnnotation, ler.TransferTo)
+	setAnnotation(&lease.ObjectMeta, ChallengerAnnotation, ler.Challenger)
+	setAnnotation(&lease.ObjectMeta, ChallengerPriorityAnnotation, itoa(ler.ChallengerPriority))
+	setAnnotation(&lease.ObjectMeta, HolderAddressAnnotation, ler.HolderAddress)
+	var md string
+	if len(ler.HolderMetadata) != 0 {
+		// a map of strings always encodes
+		b, _ := json.Marshal(ler.HolderMetadata)
+		md = string(b)
+	}
+	setAnnotation(&lease.ObjectMeta, HolderMetadataAnnotation, md)
+}
+
+func setAnnotation(meta *metav1.ObjectMeta, key, value string) {
+	if value == "" {
+		delete(meta.Annotations, key)
+		return
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[key] = value
+}
+
+// itoa formats i, returning an empty string for the zero value.
+func itoa(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
+
+func setCandidate(lease *coordinationv1.Lease, c le.Candidate) error {
+	lease.Spec.HolderIdentity = &c.Identity
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: c.LastSeen}
+	if len(c.Metadata) == 0 {
+		setAnnotation(&lease.ObjectMeta, MetadataAnnotation, "")
+		return nil
+	}
+	b, err := json.Marshal(c.Metadata)
+	if err != nil {
+		return err
+	}
+	setAnnotation(&lease.ObjectMeta, MetadataAnnotation, string(b))
+	return nil
+}
+
+func leaseToCandidate(lease *coordinationv1.Lease) (le.Candidate, error) {
+	var c le.Candidate
+	if lease.Spec.HolderIdentity != nil {
+		c.Identity = *lease.Spec.HolderIdentity
+	}
+	if lease.Spec.RenewTime != nil {
+		c.LastSeen = lease.Spec.RenewTime.Time
+	}
+	if md, ok := lease.Annotations[MetadataAnnotation]; ok {
+		if err := json.Unmarshal([]byte(md), &c.Metadata); err != nil {
+			return c, fmt.Errorf("%s: invalid metadata: %w", lease.Name, err)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/leaderelectiontest"
+)
+
+func TestLeaseLockConformance(t *testing.T) {
+	client := newFakeClient()
+	leaderelectiontest.RunLockConformance(t, func(t *testing.T, name, id string) le.Lock {
+		l, err := New("default", name, client.CoordinationV1(), Config{Identity: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	})
+}
+
+// newFakeClient returns a fake clientset enforcing the optimistic concurrency
+// of the API server on leases, which the default object tracker does not.
+func newFakeClient() *fake.Clientset {
+	c := fake.NewSimpleClientset()
+	var (
+		mu      sync.Mutex
+		version int
+	)
+	gvr := coordinationv1.SchemeGroupVersion.WithResource("leases")
+	c.PrependReactor("create", "leases", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lease := a.(k8stesting.CreateAction).GetObject().(*coordinationv1.Lease).DeepCopy()
+		version++
+		lease.ResourceVersion = strconv.Itoa(version)
+		if err := c.Tracker().Create(gvr, lease, a.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		return true, lease, nil
+	})
+	c.PrependReactor("update", "leases", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lease := a.(k8stesting.UpdateAction).GetObject().(*coordinationv1.Lease).DeepCopy()
+		cur, err := c.Tracker().Get(gvr, a.GetNamespace(), lease.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		if cur.(*coordinationv1.Lease).ResourceVersion != lease.ResourceVersion {
+			return true, nil, kerrors.NewConflict(gvr.GroupResource(), lease.Name, errors.New("the object has been modified"))
+		}
+		version++
+		lease.ResourceVersion = strconv.Itoa(version)
+		if err := c.Tracker().Update(gvr, lease, a.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		return true, lease, nil
+	})
+	return c
+}
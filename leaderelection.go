@@ -17,7 +17,9 @@ limitations under the License.
 // Package leaderelection implements leader election of a set of endpoints.
 // It uses an annotation in the endpoints object to store the record of the
 // election state. This implementation does not guarantee that only one
-// client is acting as a leader (a.k.a. fencing).
+// client is acting as a leader. Each leadership term is however given a
+// fencing token (see TokenFromContext) that downstream systems can use to
+// reject the writes of a stale leader.
 //
 // A client only acts on timestamps captured locally to infer the state of the
 // leader election. The client does not consider timestamps in the leader
@@ -53,24 +55,32 @@ limitations under the License.
 package leaderelection
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/klog/v2"
 	"k8s.io/utils/clock"
 )
 
 const (
 	JitterFactor = 1.2
+
+	// maxConflictRetries is the number of times tryAcquireOrRenew re-reads
+	// the record after a conflicting update before giving up.
+	maxConflictRetries = 3
 )
 
+// ErrNotLeader is returned by the operations only a leading LeaderElector can perform.
+var ErrNotLeader = errors.New("not leading")
+
 // New creates a LeaderElector from a Config
 func New(lec Config) (*LeaderElector, error) {
 	if lec.LeaseDuration <= lec.RenewDeadline {
@@ -98,10 +108,47 @@ func New(lec Config) (*LeaderElector, error) {
 	if lec.Lock == nil {
 		return nil, fmt.Errorf("Lock must not be nil.")
 	}
+	if lec.TransferWindow < 0 {
+		return nil, fmt.Errorf("transferWindow must not be negative")
+	}
+	if lec.TransferWindow == 0 {
+		lec.TransferWindow = lec.LeaseDuration
+	}
+	if lec.ResignCooldown < 0 {
+		return nil, fmt.Errorf("resignCooldown must not be negative")
+	}
+	if lec.SafetyFraction < 0 || lec.SafetyFraction > 1 {
+		return nil, fmt.Errorf("safetyFraction must be between 0 and 1")
+	}
+	if lec.SafetyFraction == 0 {
+		lec.SafetyFraction = float64(lec.RenewDeadline) / float64(lec.LeaseDuration)
+	}
+	if lec.Clock == nil {
+		lec.Clock = clock.RealClock{}
+	}
+	if lec.TracerProvider == nil {
+		lec.TracerProvider = otel.GetTracerProvider()
+	}
+	mp := lec.MetricsProvider
+	if mp == nil {
+		mp = globalMetricsFactory.provider()
+	}
 	le := LeaderElector{
-		config:  lec,
-		clock:   clock.RealClock{},
-		metrics: globalMetricsFactory.newLeaderMetrics(),
+		config: lec,
+		log:    newLogger(lec.Logger, lec.Name, lec.Lock),
+		clock:  lec.Clock,
+		observed: observation{
+			clock: lec.Clock,
+		},
+		metrics: newLeaderMetrics(mp),
+		tracer:  lec.TracerProvider.Tracer(instrumentationName),
+	}
+	le.dispatcher.handle = le.handle
+	if l, ok := lec.Lock.(LoggerSetter); ok && lec.Logger.GetSink() != nil {
+		l.SetLogger(le.log)
+	}
+	if c := lec.Lock.Consistency(); c != CompareAndSwap {
+		le.log.V(2).Info("the lock does not provide compare-and-swap updates: fencing tokens may be handed out twice", "consistency", c)
 	}
 	le.metrics.leaderOff(le.config.Name)
 	return &le, nil
@@ -135,6 +182,43 @@ type Config struct {
 	//
 	// Core clients default this value to 2 seconds.
 	RetryPeriod time.Duration
+	// TransferWindow is the duration during which only the successor chosen by
+	// Transfer may acquire the lease. The other candidates fall back to the
+	// normal behaviour once it has passed.
+	//
+	// It defaults to LeaseDuration.
+	TransferWindow time.Duration
+	// ResignCooldown is the duration during which the client does not campaign
+	// after Resign, leaving the other candidates a chance to acquire the lease.
+	ResignCooldown time.Duration
+	// SafetyFraction is the fraction of LeaseDuration after which the leader
+	// context expires, starting from the last successful renewal: the context
+	// given to OnStartedLeading and to the Runnables is cancelled with
+	// context.DeadlineExceeded as cause once it passed, before any other
+	// candidate may take over, and its Deadline is pushed back on each renewal.
+	//
+	// It must be between 0 and 1, and defaults to RenewDeadline/LeaseDuration.
+	SafetyFraction float64
+
+	// Priority is the priority of the candidate. A candidate asks the leader
+	// with a lower priority to yield, which then transfers the lease to the
+	// challenger with the highest priority (see LeaderElector.Transfer).
+	//
+	// It defaults to zero, so that candidates never challenge each other.
+	Priority int
+	// PriorityFunc, if set, is called to get the priority of the candidate each
+	// time it is published in the record, e.g. to derive it from the load.
+	// It overrides Priority.
+	PriorityFunc func() int
+
+	// Address is the address the client advertises in the record while it
+	// holds the lease, e.g. the URL of its API, so that the followers can reach
+	// the leader. It is optional.
+	Address string
+	// Metadata is published in the record while the client holds the lease,
+	// and along with the identity of the candidate when the Lock implements
+	// CandidateRegistry, see LeaderElector.Candidates.
+	Metadata map[string]string
 
 	// Callbacks are callbacks that are triggered during certain lifecycle
 	// events of the LeaderElector
@@ -153,59 +237,228 @@ type Config struct {
 
 	// Name is the name of the resource lock for debugging
 	Name string
+
+	// Clock drives the timers of the acquire, renew and release loops and
+	// timestamps the observed records. It defaults to the real clock, tests may
+	// set a k8s.io/utils/clock/testing.FakeClock to step through lease expiry.
+	Clock clock.Clock
+
+	// Logger is the logger of the client, which adds the name, the lock and
+	// the identity to its log lines. It defaults to the klog logger.
+	// It is passed to the Lock in the context of its operations, so that
+	// the backends logging with klog.FromContext use it, and set as the
+	// logger of the Locks implementing LoggerSetter.
+	Logger logr.Logger
+
+	// TracerProvider provides the tracer of the spans around the Lock calls.
+	// It defaults to the global OpenTelemetry TracerProvider.
+	TracerProvider trace.TracerProvider
+
+	// MetricsProvider, if set, provides the metrics of the client instead of
+	// the provider installed by SetProvider. It may implement
+	// ExtendedMetricsProvider.
+	MetricsProvider MetricsProvider
 }
 
 // Callbacks are callbacks that are triggered during certain
-// lifecycle events of the LeaderElector. These are invoked asynchronously.
-//
-// possible future callbacks:
-//   - OnChallenge()
+// lifecycle events of the LeaderElector. These are invoked asynchronously,
+// one at a time and in the order of the events sent by LeaderElector.Events,
+// so that a callback blocking for too long delays the next ones.
 type Callbacks struct {
-	// OnStartedLeading is called when a LeaderElector client starts leading
+	// OnStartedLeading is called when a LeaderElector client starts leading.
+	// It runs in its own goroutine, until its context is done.
 	OnStartedLeading func(context.Context)
-	// OnStoppedLeading is called when a LeaderElector client stops leading
+	// OnStoppedLeading is called when a LeaderElector client stops leading.
+	// Run does not return before it returned.
 	OnStoppedLeading func()
 	// OnNewLeader is called when the client observes a leader that is
 	// not the previously observed leader. This includes the first observed
 	// leader when the client starts.
 	OnNewLeader func(identity string)
+	// OnChallenge, if set, is called when the lease is contested: when the
+	// leader reads a record it did not write, conflicts on an update, or is
+	// asked to yield by another candidate, and when a follower observes that
+	// the leader renewals stalled.
+	OnChallenge func(Challenge)
 }
 
 // LeaderElector is a leader election client.
 type LeaderElector struct {
 	config Config
 	// internal bookkeeping
-	observedRecord    Record
-	observedRawRecord []byte
-	observedTime      time.Time
+	observed        observation
+	observedVersion Version
 	// used to implement OnNewLeader(), may lag slightly from the
-	// value observedRecord.HolderIdentity if the transition has
+	// value observed.record.HolderIdentity if the transition has
 	// not yet been reported.
 	reportedLeader string
 
+	// mu serializes the updates of the record, which Transfer may perform
+	// concurrently with the renew loop, and protects the fields below.
+	mu sync.Mutex
+	// leading is true while the client holds a leadership term, whose
+	// fencing token is stored in token.
+	leading bool
+	token   int64
+	// renewed is the time the last successful acquisition or renewal started,
+	// and leases are the contexts whose deadline it extends.
+	renewed time.Time
+	leases  []*leaseContext
+	// released is true once the lease of the current term was released or
+	// transferred, and reason is the reason the current term ended.
+	released bool
+	reason   StopReason
+	// stalled is the observation time of the last stalled lease reported.
+	stalled time.Time
+	// failure is the error of the last failed attempt to acquire or renew the lease.
+	failure error
+	// lastError holds the failure of the last attempt, readable without mu,
+	// and paused is true while the client does not campaign.
+	lastError atomic.Pointer[error]
+	paused    atomic.Bool
+	// active is leading, readable without mu, but cleared as soon as the
+	// renewal of the lease failed.
+	active atomic.Bool
+	// reached is the time in nanoseconds the lock last answered a call, or
+	// the client started running if it never did. It is read without mu,
+	// which is held while the lock is unreachable.
+	reached atomic.Int64
+	// stop cancels the context of the current term, and stopped is closed
+	// once OnStoppedLeading returned.
+	stop    context.CancelFunc
+	stopped chan struct{}
+
 	// clock is wrapper around time to allow for less flaky testing
 	clock clock.Clock
 
-	// used to lock the observedRecord
-	observedRecordLock sync.Mutex
-
+	log     logr.Logger
 	metrics leaderMetricsAdapter
+	tracer  trace.Tracer
+
+	// dispatcher delivers the events to the callbacks and to the stream.
+	dispatcher queue[dispatch]
+	streamLock sync.Mutex
+	stream     *queue[Event]
+	events     chan Event
+
+	runnablesLock sync.Mutex
+	runnables     []*runnable
+	running       []*running
 }
 
 // Run starts the leader election loop. Run will not return
 // before leader election loop is stopped by ctx or it has
-// stopped holding the leader lease
+// stopped holding the leader lease, unless it stopped
+// holding it because of Resign.
 func (le *LeaderElector) Run(ctx context.Context) {
+	le.run(ctx, func(reason StopReason) (time.Duration, bool) {
+		return 0, reason == StopResign
+	})
+}
+
+// run campaigns for the lease until ctx is done or next returns false. next is
+// called after each leadership term with the reason it ended, and returns the
+// delay before campaigning again, which is at least the ResignCooldown after
+// Resign.
+func (le *LeaderElector) run(ctx context.Context, next func(reason StopReason) (time.Duration, bool)) {
 	defer runtime.HandleCrash()
 
-	if !le.acquire(ctx) {
-		return // ctx signalled done
-	}
-	defer le.config.Callbacks.OnStoppedLeading()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	go le.config.Callbacks.OnStartedLeading(ctx)
-	le.renew(ctx)
+
+	le.reached.CompareAndSwap(0, le.clock.Now().UnixNano())
+	if r, ok := le.config.Lock.(CandidateRegistry); ok {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			le.heartbeat(ctx, r)
+		}()
+		defer func() {
+			cancel()
+			<-done
+			le.unregister(r)
+		}()
+	}
+
+	for {
+		reason, ok := le.term(ctx)
+		if !ok {
+			return // ctx signalled done
+		}
+		d, ok := next(reason)
+		if !ok {
+			return
+		}
+		if reason == StopResign && d < le.config.ResignCooldown {
+			d = le.config.ResignCooldown
+		}
+		le.reset()
+		if !sleep(ctx, le.clock, d) {
+			return
+		}
+	}
+}
+
+// term acquires the lease and renews it until the leadership term ends. It
+// returns the reason the term ended, and false if the lease was not acquired.
+func (le *LeaderElector) term(ctx context.Context) (reason StopReason, acquired bool) {
+	tctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopped := make(chan struct{})
+	defer close(stopped)
+	le.mu.Lock()
+	le.stop = cancel
+	le.stopped = stopped
+	le.reason = StopContext
+	le.mu.Unlock()
+
+	if !le.acquire(tctx) {
+		return StopContext, false
+	}
+	// the runnables are not stopped with the term context, but one after the
+	// other before OnStoppedLeading, see stopRunnables
+	le.mu.Lock()
+	deadline := le.deadline()
+	lctx := newLeaseContext(withToken(tctx, le.token), le.clock, deadline)
+	rctx := newLeaseContext(withToken(context.Background(), le.token), le.clock, deadline)
+	le.leases = []*leaseContext{lctx, rctx}
+	le.mu.Unlock()
+	defer rctx.cancel(context.Canceled)
+	defer func() {
+		le.mu.Lock()
+		le.setLeading(false)
+		reason = le.reason
+		t := Lost
+		if le.released {
+			t = Released
+		}
+		le.mu.Unlock()
+		le.metrics.leaderOff(le.config.Name)
+		done := make(chan struct{})
+		le.dispatcher.push(dispatch{event: le.event(t, nil), done: done})
+		<-done
+	}()
+	le.dispatcher.push(dispatch{event: le.event(Acquired, nil), ctx: lctx, runnables: rctx})
+	le.renew(tctx)
+	return StopContext, true
+}
+
+// setLeading sets leading, and active along with it. It must be called with mu held.
+func (le *LeaderElector) setLeading(leading bool) {
+	le.leading = leading
+	le.active.Store(leading)
+}
+
+// reset clears the state of the last leadership term, so that the next one
+// starts as a new Run would. The observed record is kept: it is needed to
+// honour the current lease and to never hand out a fencing token twice. So is
+// the reported leader, which did not change with the term.
+func (le *LeaderElector) reset() {
+	le.mu.Lock()
+	le.token = 0
+	le.released = false
+	le.leases = nil
+	le.mu.Unlock()
 }
 
 // RunOrDie starts a client with the provided config or panics if the config
@@ -240,20 +493,24 @@ func (le *LeaderElector) acquire(ctx context.Context) bool {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	succeeded := false
-	desc := le.config.Lock.Describe()
-	klog.Infof("attempting to acquire leader lease %v...", desc)
-	wait.JitterUntil(func() {
+	le.log.Info("attempting to acquire leader lease...")
+	jitterUntil(ctx, le.clock, func() {
+		le.emit(AcquireAttempt, nil)
 		succeeded = le.tryAcquireOrRenew(ctx)
 		le.maybeReportTransition()
 		if !succeeded {
-			klog.V(4).Infof("failed to acquire lease %v", desc)
+			le.log.V(4).Info("failed to acquire lease")
 			return
 		}
+		le.mu.Lock()
+		le.setLeading(true)
+		le.released = false
+		le.mu.Unlock()
 		le.config.Lock.RecordEvent("became leader")
 		le.metrics.leaderOn(le.config.Name)
-		klog.Infof("successfully acquired lease %v", desc)
+		le.log.Info("successfully acquired lease", "token", le.token)
 		cancel()
-	}, le.config.RetryPeriod, JitterFactor, true, ctx.Done())
+	}, le.config.RetryPeriod, JitterFactor)
 	return succeeded
 }
 
@@ -262,23 +519,40 @@ func (le *LeaderElector) renew(ctx context.Context) {
 	defer le.config.Lock.RecordEvent("stopped leading")
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	wait.Until(func() {
-		timeoutCtx, timeoutCancel := context.WithTimeout(ctx, le.config.RenewDeadline)
+	jitterUntil(ctx, le.clock, func() {
+		timeoutCtx, timeoutCancel := withTimeout(ctx, le.clock, le.config.RenewDeadline)
 		defer timeoutCancel()
-		err := wait.PollImmediateUntil(le.config.RetryPeriod, func() (bool, error) {
-			return le.tryAcquireOrRenew(timeoutCtx), nil
-		}, timeoutCtx.Done())
+		err := pollImmediateUntil(timeoutCtx, le.clock, le.config.RetryPeriod, func() bool {
+			return le.tryAcquireOrRenew(timeoutCtx)
+		})
 
 		le.maybeReportTransition()
-		desc := le.config.Lock.Describe()
 		if err == nil {
-			klog.V(5).Infof("successfully renewed lease %v", desc)
+			le.mu.Lock()
+			for _, l := range le.leases {
+				l.extend(le.deadline())
+			}
+			le.mu.Unlock()
+			le.emit(Renewed, nil)
+			le.log.V(5).Info("successfully renewed lease")
 			return
 		}
 		le.metrics.leaderOff(le.config.Name)
-		klog.Infof("failed to renew lease %v: %v", desc, err)
+		le.log.Info("failed to renew lease", "reason", err)
+		// the run context was cancelled, or the lease transferred or resigned
+		if ctx.Err() == nil {
+			le.mu.Lock()
+			le.active.Store(false)
+			le.reason = StopRenewDeadline
+			if r := le.getObservedRecord(); r.HolderIdentity != le.config.Lock.Identity() || tokenOf(&r) != le.token {
+				le.reason = StopConflict
+			}
+			le.metrics.renewFailure(le.config.Name, le.failureClass())
+			le.mu.Unlock()
+			le.emit(RenewFailed, err)
+		}
 		cancel()
-	}, le.config.RetryPeriod, ctx.Done())
+	}, le.config.RetryPeriod, 0)
 
 	// if we hold the lease, give it up
 	if le.config.ReleaseOnCancel {
@@ -286,98 +560,406 @@ func (le *LeaderElector) renew(ctx context.Context) {
 	}
 }
 
+// Transfer hands the leadership over to the candidate with the given identity.
+// It records the successor in the lock record and stops leading, as if the run
+// context was cancelled. Only the successor may then acquire the lease during
+// the Config.TransferWindow.
+//
+// It returns ErrNotLeader if the client is not leading.
+func (le *LeaderElector) Transfer(ctx context.Context, identity string) error {
+	if identity == "" || identity == le.config.Lock.Identity() {
+		return fmt.Errorf("invalid successor identity %q", identity)
+	}
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	if !le.leading || !le.IsLeader() {
+		return ErrNotLeader
+	}
+	return le.transfer(ctx, identity, le.observedVersion)
+}
+
+// transfer records the successor in the lock record and stops leading.
+// It must be called with mu held, while leading.
+func (le *LeaderElector) transfer(ctx context.Context, identity string, version Version) error {
+	old := le.getObservedRecord()
+	leaderElectionRecord := Record{
+		HolderIdentity:            old.HolderIdentity,
+		HolderPriority:            old.HolderPriority,
+		HolderAddress:             old.HolderAddress,
+		HolderMetadata:            old.HolderMetadata,
+		LeaseDurationMilliSeconds: int(le.config.TransferWindow / time.Millisecond),
+		AcquireTime:               old.AcquireTime,
+		RenewTime:                 le.clock.Now().UnixMilli(),
+		LeaderTransitions:         old.LeaderTransitions,
+		TransferTo:                identity,
+	}.upgrade(&old)
+	octx, done := le.operation(ctx, "release", "update")
+	version, err := le.config.Lock.Update(octx, leaderElectionRecord, version)
+	done(err)
+	if err != nil {
+		err = fmt.Errorf("failed to transfer lock %v to %s: %w", le.config.Lock.Describe(), identity, err)
+		if !errors.Is(err, ErrConflict) {
+			le.emit(BackendError, err)
+		}
+		return err
+	}
+	le.setObservedRecord(&leaderElectionRecord)
+	le.observedVersion = version
+	le.setLeading(false)
+	le.released = true
+	le.reason = StopTransfer
+	le.stop()
+	le.config.Lock.RecordEvent("transferred leadership to " + identity)
+	le.log.Info("transferred lease", "successor", identity)
+	return nil
+}
+
+// Resign releases the lease and stops leading, as if the run context was
+// cancelled with ReleaseOnCancel set, but Run keeps going: the client campaigns
+// again once Config.ResignCooldown has passed. It returns once OnStoppedLeading
+// returned, and must thus not be called from the callbacks other than
+// OnStartedLeading.
+//
+// It returns ErrNotLeader if the client is not leading.
+func (le *LeaderElector) Resign(ctx context.Context) error {
+	le.mu.Lock()
+	if !le.leading || !le.IsLeader() {
+		le.mu.Unlock()
+		return ErrNotLeader
+	}
+	if err := le.releaseLocked(ctx); err != nil {
+		le.mu.Unlock()
+		return fmt.Errorf("failed to release lock %v: %w", le.config.Lock.Describe(), err)
+	}
+	le.setLeading(false)
+	le.reason = StopResign
+	le.stop()
+	stopped := le.stopped
+	le.mu.Unlock()
+	le.config.Lock.RecordEvent("resigned")
+	le.log.Info("resigned lease")
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // release attempts to release the leader lease if we have acquired it.
 func (le *LeaderElector) release() bool {
-	if !le.IsLeader() {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	// the lease was transferred: it is not ours to release anymore
+	if !le.leading || !le.IsLeader() {
 		return true
 	}
+	if err := le.releaseLocked(context.TODO()); err != nil {
+		le.log.Error(err, "failed to release lock")
+		return false
+	}
+	return true
+}
+
+// releaseLocked writes an expired record. It must be called with mu held, while leading.
+func (le *LeaderElector) releaseLocked(ctx context.Context) error {
 	now := le.clock.Now()
+	old := le.getObservedRecord()
 	leaderElectionRecord := Record{
-		HolderIdentity:            le.observedRecord.HolderIdentity,
-		LeaderTransitions:         le.observedRecord.LeaderTransitions,
+		HolderIdentity:            old.HolderIdentity,
+		LeaderTransitions:         old.LeaderTransitions,
 		LeaseDurationMilliSeconds: 1,
 		RenewTime:                 now.UnixMilli(),
 		AcquireTime:               now.UnixMilli(),
-	}
-	if err := le.config.Lock.Update(context.TODO(), leaderElectionRecord); err != nil {
-		klog.Errorf("Failed to release lock: %v", err)
-		return false
+	}.upgrade(&old)
+	octx, done := le.operation(ctx, "release", "update")
+	version, err := le.config.Lock.Update(octx, leaderElectionRecord, le.observedVersion)
+	done(err)
+	if err != nil {
+		if !errors.Is(err, ErrConflict) {
+			le.emit(BackendError, err)
+		}
+		return err
 	}
 
 	le.setObservedRecord(&leaderElectionRecord)
-	return true
+	le.observedVersion = version
+	le.released = true
+	return nil
+}
+
+// failureClass returns the class of error which made the renewal of the lease
+// fail: "lost" if another client took over, "conflict" if the updates kept
+// conflicting, "backend" if the lock failed, and "timeout" otherwise. It must
+// be called with mu held.
+func (le *LeaderElector) failureClass() string {
+	switch err := le.failure; {
+	case le.reason == StopConflict:
+		return "lost"
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled):
+		return "backend"
+	default:
+		return "timeout"
+	}
 }
 
 // tryAcquireOrRenew tries to acquire a leader lease if it is not already acquired,
 // else it tries to renew the lease if it has already been acquired. Returns true
 // on success else returns false.
-func (le *LeaderElector) tryAcquireOrRenew(ctx context.Context) bool {
+// The record is compared-and-swapped: if it changed between the time it was read
+// and the time it was written, the decision is taken again on the new record.
+func (le *LeaderElector) tryAcquireOrRenew(ctx context.Context) (succeeded bool) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	ctx, span := le.tracer.Start(ctx, "leaderelection.tryAcquireOrRenew", trace.WithAttributes(le.attributes(le.phase())...))
+	defer func() {
+		if le.leading {
+			le.metrics.sinceRenew(le.config.Name, le.clock.Since(le.renewed))
+		}
+		span.SetAttributes(attrSucceeded.Bool(succeeded))
+		span.End()
+	}()
+	for i := 0; ; i++ {
+		// Transfer may have stopped us while we were waiting for the lock
+		if ctx.Err() != nil {
+			return false
+		}
+		ok, err := le.tryAcquireOrRenewOnce(ctx)
+		le.failure = err
+		le.lastError.Store(&err)
+		if !errors.Is(err, ErrConflict) {
+			return ok
+		}
+		if i == maxConflictRetries {
+			le.log.Error(err, "giving up after conflicts", "conflicts", i+1)
+			return false
+		}
+		if le.leading {
+			le.notifyChallenge(ChallengeConflict, "", le.getObservedRecord(), err)
+		}
+		le.log.V(4).Info("lock changed concurrently, retrying", "reason", err)
+	}
+}
+
+// tryAcquireOrRenewOnce is a single compare-and-swap attempt of tryAcquireOrRenew.
+// The returned error is only set when the Lock failed.
+func (le *LeaderElector) tryAcquireOrRenewOnce(ctx context.Context) (bool, error) {
 	now := le.clock.Now()
+	phase := le.phase()
+	priority := le.priority()
 	leaderElectionRecord := Record{
+		Version:                   RecordVersion,
 		HolderIdentity:            le.config.Lock.Identity(),
+		HolderPriority:            priority,
+		HolderAddress:             le.config.Address,
+		HolderMetadata:            le.config.Metadata,
 		LeaseDurationMilliSeconds: int(le.config.LeaseDuration / time.Millisecond),
 		RenewTime:                 now.UnixMilli(),
 		AcquireTime:               now.UnixMilli(),
 	}
 
 	// 1. obtain or create the ElectionRecord
-	oldLeaderElectionRecord, oldLeaderElectionRawRecord, err := le.config.Lock.Get(ctx)
+	octx, done := le.operation(ctx, phase, "get")
+	oldLeaderElectionRecord, oldLeaderElectionRawRecord, oldVersion, err := le.config.Lock.Get(octx)
+	done(err)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
-			klog.Errorf("error retrieving resource lock %v: %v", le.config.Lock.Describe(), err)
-			return false
+			le.log.Error(err, "error retrieving resource lock")
+			le.emit(BackendError, err)
+			return false, err
 		}
-		if err = le.config.Lock.Create(ctx, leaderElectionRecord); err != nil {
-			klog.Errorf("error initially creating leader election record: %v", err)
-			return false
+		if le.leading {
+			le.log.Error(err, "resource lock disappeared while leading")
+			return false, nil
+		}
+		if le.paused.Load() {
+			le.log.V(4).Info("campaigning paused, not creating the lock")
+			return false, nil
+		}
+		// the record may have been deleted after we observed it: never go back
+		// on a fencing token that may already have been handed out
+		if old, ok := le.observed.get(); ok {
+			leaderElectionRecord.LeaderTransitions = old.LeaderTransitions + 1
+		}
+		octx, done := le.operation(ctx, phase, "create")
+		version, err := le.config.Lock.Create(octx, leaderElectionRecord)
+		done(err)
+		if err != nil {
+			if !errors.Is(err, ErrConflict) {
+				le.log.Error(err, "error initially creating leader election record")
+				le.emit(BackendError, err)
+			}
+			return false, err
 		}
 
 		le.setObservedRecord(&leaderElectionRecord)
+		le.observedVersion = version
+		le.token = tokenOf(&leaderElectionRecord)
+		le.renewed = now
 
-		return true
+		return true, nil
 	}
 
 	// 2. Record obtained, check the Identity & Time
-	if !bytes.Equal(le.observedRawRecord, oldLeaderElectionRawRecord) {
-		le.setObservedRecord(oldLeaderElectionRecord)
-
-		le.observedRawRecord = oldLeaderElectionRawRecord
+	last := le.getObservedRecord()
+	le.observed.observe(oldLeaderElectionRecord, oldLeaderElectionRawRecord)
+	le.observedVersion = oldVersion
+	held := le.observed.held(oldLeaderElectionRecord, now)
+	le.checkChallenges(&last, oldLeaderElectionRecord, held)
+	le.observeLease(&last, oldLeaderElectionRecord, now)
+	if !le.leading && le.paused.Load() {
+		le.log.V(4).Info("campaigning paused", "holder", oldLeaderElectionRecord.HolderIdentity)
+		return false, nil
 	}
-	if len(oldLeaderElectionRecord.HolderIdentity) > 0 &&
-		le.observedTime.Add(time.Millisecond*time.Duration(oldLeaderElectionRecord.LeaseDurationMilliSeconds)).After(now) &&
-		!le.IsLeader() {
-		klog.V(4).Infof("lock is held by %v and has not yet expired", oldLeaderElectionRecord.HolderIdentity)
-		return false
+	switch transferTo := oldLeaderElectionRecord.TransferTo; {
+	case held && transferTo == le.config.Lock.Identity():
+		le.log.Info("lock is being transferred to us", "holder", oldLeaderElectionRecord.HolderIdentity)
+	case held && transferTo != "":
+		le.log.V(4).Info("lock is being transferred and the transfer has not yet expired", "successor", transferTo)
+		return false, nil
+	case held && !le.IsLeader():
+		if le.shouldChallenge(oldLeaderElectionRecord, priority) {
+			return false, le.challenge(ctx, oldLeaderElectionRecord, oldVersion, priority)
+		}
+		le.log.V(4).Info("lock is held and has not yet expired", "holder", oldLeaderElectionRecord.HolderIdentity)
+		return false, nil
+	}
+	// the record does not belong to our term anymore: another client (possibly
+	// using the same identity) took over in between.
+	if le.leading && (!le.IsLeader() || tokenOf(oldLeaderElectionRecord) != le.token) {
+		le.log.Error(nil, "lock was taken over by a new term", "term", oldLeaderElectionRecord.LeaderTransitions, "token", le.token)
+		return false, nil
+	}
+	// a candidate with a higher priority asked us to yield
+	if c := oldLeaderElectionRecord.Challenger; le.leading && c != "" && oldLeaderElectionRecord.ChallengerPriority > priority {
+		le.log.Info("lock challenged by a candidate with a higher priority, yielding", "challenger", c, "challengerPriority", oldLeaderElectionRecord.ChallengerPriority, "priority", priority)
+		return false, le.transfer(ctx, c, oldVersion)
 	}
 
 	// 3. We're going to try to update. The leaderElectionRecord is set to it's default
 	// here. Let's correct it before updating.
-	if le.IsLeader() {
+	// A new term is started each time we are not already leading, even if the
+	// record holds our identity (e.g. after a restart), so that the fencing
+	// token strictly increases across terms.
+	if le.leading {
 		leaderElectionRecord.AcquireTime = oldLeaderElectionRecord.AcquireTime
 		leaderElectionRecord.LeaderTransitions = oldLeaderElectionRecord.LeaderTransitions
 	} else {
 		leaderElectionRecord.LeaderTransitions = oldLeaderElectionRecord.LeaderTransitions + 1
 	}
 
-	// update the lock itself
-	if err = le.config.Lock.Update(ctx, leaderElectionRecord); err != nil {
-		klog.Errorf("Failed to update lock: %v", err)
+	// update the lock itself, keeping the fields of newer versions
+	leaderElectionRecord = leaderElectionRecord.upgrade(oldLeaderElectionRecord)
+	octx, done = le.operation(ctx, phase, "update")
+	version, err := le.config.Lock.Update(octx, leaderElectionRecord, oldVersion)
+	done(err)
+	if err != nil {
+		if !errors.Is(err, ErrConflict) {
+			le.log.Error(err, "failed to update lock")
+			le.emit(BackendError, err)
+		}
+		return false, err
+	}
+	le.log.V(4).Info("lock renewed")
+
+	le.setObservedRecord(&leaderElectionRecord)
+	le.observedVersion = version
+	le.token = tokenOf(&leaderElectionRecord)
+	le.renewed = now
+	return true, nil
+}
+
+// deadline returns the deadline of the leader contexts, SafetyFraction of
+// LeaseDuration after the last successful renewal. It must be called with mu held.
+func (le *LeaderElector) deadline() time.Time {
+	return le.renewed.Add(time.Duration(le.config.SafetyFraction * float64(le.config.LeaseDuration)))
+}
+
+// observeLease updates the metrics derived from the record r read from the
+// lock at now, last being the record observed before.
+func (le *LeaderElector) observeLease(last, r *Record, now time.Time) {
+	if r.AcquireTime != 0 {
+		le.metrics.leaseAge(le.config.Name, now.Sub(time.UnixMilli(r.AcquireTime)))
+	}
+	// our own renewals are measured by tryAcquireOrRenew
+	if r.HolderIdentity == le.config.Lock.Identity() {
+		return
+	}
+	le.metrics.sinceRenew(le.config.Name, now.Sub(le.observed.at()))
+	if !written(r, last) && r.RenewTime != 0 {
+		le.metrics.clockSkew(le.config.Name, now.Sub(time.UnixMilli(r.RenewTime)))
+	}
+}
+
+// checkChallenges reports the challenges revealed by the record r read from
+// the lock, last being the record observed before.
+func (le *LeaderElector) checkChallenges(last, r *Record, held bool) {
+	id := le.config.Lock.Identity()
+	switch {
+	case le.leading && !written(r, last) && r.Challenger != "" && r.Challenger != id:
+		le.notifyChallenge(ChallengeContender, r.Challenger, *r, nil)
+	case le.leading && !written(r, last):
+		le.notifyChallenge(ChallengeForeignWrite, r.HolderIdentity, *r, nil)
+	case !le.leading && held && r.HolderIdentity != id && r.TransferTo == "":
+		at := le.observed.at()
+		if le.clock.Since(at) > le.config.RenewDeadline && !at.Equal(le.stalled) {
+			le.stalled = at
+			le.notifyChallenge(ChallengeStalled, r.HolderIdentity, *r, nil)
+		}
+	}
+}
+
+// priority returns the current priority of the candidate.
+func (le *LeaderElector) priority() int {
+	if le.config.PriorityFunc != nil {
+		return le.config.PriorityFunc()
+	}
+	return le.config.Priority
+}
+
+// shouldChallenge returns true if a candidate with the given priority should
+// ask the holder of the record to yield.
+func (le *LeaderElector) shouldChallenge(ler *Record, priority int) bool {
+	if priority <= ler.HolderPriority || ler.Challenger == le.config.Lock.Identity() {
 		return false
 	}
-	klog.V(4).Infof("lock renewed")
+	return ler.Challenger == "" || priority > ler.ChallengerPriority
+}
 
+// challenge asks the holder of the record to yield by publishing our priority
+// in the record. The holder transfers the lease to the challenger with the
+// highest priority on its next renewal.
+func (le *LeaderElector) challenge(ctx context.Context, old *Record, version Version, priority int) error {
+	leaderElectionRecord := old.upgrade(old)
+	leaderElectionRecord.Challenger = le.config.Lock.Identity()
+	leaderElectionRecord.ChallengerPriority = priority
+	octx, done := le.operation(ctx, "acquire", "update")
+	version, err := le.config.Lock.Update(octx, leaderElectionRecord, version)
+	done(err)
+	if err != nil {
+		if !errors.Is(err, ErrConflict) {
+			le.log.Error(err, "failed to challenge lock")
+			le.emit(BackendError, err)
+		}
+		return err
+	}
 	le.setObservedRecord(&leaderElectionRecord)
-	return true
+	le.observedVersion = version
+	le.config.Lock.RecordEvent("challenged " + old.HolderIdentity)
+	le.log.Info("challenged the holder with a higher priority", "holder", old.HolderIdentity, "holderPriority", old.HolderPriority, "priority", priority)
+	return nil
 }
 
 func (le *LeaderElector) maybeReportTransition() {
-	if le.observedRecord.HolderIdentity == le.reportedLeader {
+	holder := le.getObservedRecord().HolderIdentity
+	if holder == le.reportedLeader {
 		return
 	}
-	le.reportedLeader = le.observedRecord.HolderIdentity
-	if le.config.Callbacks.OnNewLeader != nil {
-		go le.config.Callbacks.OnNewLeader(le.reportedLeader)
-	}
+	le.reportedLeader = holder
+	le.metrics.transition(le.config.Name)
+	le.emit(NewLeaderObserved, nil)
 }
 
 // Check will determine if the current lease is expired by more than timeout.
@@ -389,28 +971,48 @@ func (le *LeaderElector) Check(maxTolerableExpiredLease time.Duration) error {
 	// If we are more than timeout seconds after the lease duration that is past the timeout
 	// on the lease renew. Time to start reporting ourselves as unhealthy. We should have
 	// died but conditions like deadlock can prevent this. (See #70819)
-	if le.clock.Since(le.observedTime) > le.config.LeaseDuration+maxTolerableExpiredLease {
+	if le.clock.Since(le.observed.at()) > le.config.LeaseDuration+maxTolerableExpiredLease {
 		return fmt.Errorf("failed election to renew leadership on lease %s", le.config.Name)
 	}
 
 	return nil
 }
 
-// setObservedRecord will set a new observedRecord and update observedTime to the current time.
-// Protect critical sections with lock.
-func (le *LeaderElector) setObservedRecord(observedRecord *Record) {
-	le.observedRecordLock.Lock()
-	defer le.observedRecordLock.Unlock()
+// CheckLeader returns an error if the client is not the leader, or if it
+// failed to renew the lease as reported by Check. It is meant for readiness
+// checks of the services only the leader serves.
+func (le *LeaderElector) CheckLeader(maxTolerableExpiredLease time.Duration) error {
+	if !le.IsLeader() {
+		return fmt.Errorf("%w lease %s: the leader is %q", ErrNotLeader, le.config.Name, le.GetLeader())
+	}
+	return le.Check(maxTolerableExpiredLease)
+}
+
+// CheckFollower returns an error if the client is not the leader and the lock
+// did not answer for more than maxUnreachable, as a follower unable to reach
+// the lock could not take over the lease. The leader is checked by Check.
+func (le *LeaderElector) CheckFollower(maxUnreachable time.Duration) error {
+	if le.IsLeader() {
+		return nil
+	}
+	reached := le.reached.Load()
+	if reached == 0 {
+		// not running yet
+		return nil
+	}
+	if d := le.clock.Since(time.Unix(0, reached)); d > maxUnreachable {
+		return fmt.Errorf("lock %s unreachable for %v", le.config.Lock.Describe(), d.Truncate(time.Millisecond))
+	}
+	return nil
+}
 
-	le.observedRecord = *observedRecord
-	le.observedTime = le.clock.Now()
+// setObservedRecord will set a new observed record and update the observation time to the current time.
+func (le *LeaderElector) setObservedRecord(observedRecord *Record) {
+	le.observed.set(observedRecord)
 }
 
-// getObservedRecord returns observersRecord.
-// Protect critical sections with lock.
+// getObservedRecord returns the observed record.
 func (le *LeaderElector) getObservedRecord() Record {
-	le.observedRecordLock.Lock()
-	defer le.observedRecordLock.Unlock()
-
-	return le.observedRecord
+	r, _ := le.observed.get()
+	return r
 }
@@ -0,0 +1,1350 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/memory"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+
+	// settleTimeout bounds the real time step waits for the electors.
+	settleTimeout = 5 * time.Second
+)
+
+func TestMain(m *testing.M) {
+	// the electors log to memory, errors included, and the local time zone
+	// used by the log headers is loaded upfront: the fake clock cannot tell
+	// they are busy in a system call, see fakeClock.settle
+	var logs logBuffer
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	flags.Set("logtostderr", "false")
+	flags.Set("stderrthreshold", "FATAL")
+	klog.SetOutput(&logs)
+	time.Now().Zone()
+	code := m.Run()
+	klog.Flush()
+	if code != 0 {
+		os.Stderr.Write(logs.Bytes())
+	}
+	os.Exit(code)
+}
+
+// logBuffer is a bytes.Buffer safe for concurrent writes.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+// elector is a LeaderElector running on a memory lock, reporting its
+// leadership changes on its channels.
+type elector struct {
+	*le.LeaderElector
+	id      string
+	started chan struct{}
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+func newElector(t *testing.T, ctx context.Context, s *memory.Store, clk *fakeClock, id string, opts ...func(c *le.Config)) *elector {
+	e := &elector{
+		id:      id,
+		started: make(chan struct{}, 1),
+		stopped: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	c := le.Config{
+		Lock:          memory.New(s, "test", id),
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Clock:         clk,
+		Name:          "test",
+		Callbacks: le.Callbacks{
+			OnStartedLeading: func(context.Context) {
+				e.started <- struct{}{}
+			},
+			OnStoppedLeading: func() {
+				e.stopped <- struct{}{}
+			},
+		},
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+	var err error
+	if e.LeaderElector, err = le.New(c); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer close(e.done)
+		e.Run(ctx)
+	}()
+	return e
+}
+
+// fakeClock is a FakeClock keeping track of its timers, so that the tests can
+// wait for the electors to wait on it, see settle.
+type fakeClock struct {
+	*clocktesting.FakeClock
+
+	mu     sync.Mutex
+	timers map[*fakeTimer]struct{}
+	// calls counts the calls made to the timers
+	calls int
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		FakeClock: clocktesting.NewFakeClock(time.Now()),
+		timers:    make(map[*fakeTimer]struct{}),
+	}
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) clock.Timer {
+	t := &fakeTimer{Timer: c.FakeClock.NewTimer(d), clock: c}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.deadline = c.Now().Add(d)
+	c.timers[t] = struct{}{}
+	c.calls++
+	return t
+}
+
+// settle waits until the electors wait on the clock: each pending timer is
+// waited on, each fired one was received, and no timer was used during a few
+// scheduling rounds. It gives up after settleTimeout if some never wait.
+func (c *fakeClock) settle() {
+	c.mu.Lock()
+	calls := c.calls
+	c.mu.Unlock()
+	for idle, deadline := 0, time.Now().Add(settleTimeout); idle < 3 && time.Now().Before(deadline); {
+		for i := 0; i < 10; i++ {
+			runtime.Gosched()
+		}
+		c.mu.Lock()
+		if c.calls == calls && c.waited() {
+			idle++
+		} else {
+			idle, calls = 0, c.calls
+		}
+		c.mu.Unlock()
+	}
+}
+
+// waited returns whether each timer is waited on or was received. It must be
+// called with mu held.
+func (c *fakeClock) waited() bool {
+	now := c.Now()
+	for t := range c.timers {
+		if t.deadline.After(now) && !t.waited || !t.deadline.After(now) && len(t.Timer.C()) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeTimer records the calls made to a timer of a fakeClock.
+type fakeTimer struct {
+	clock.Timer
+	clock *fakeClock
+	// deadline is the time the timer fires, and waited whether its channel
+	// was requested since it was set.
+	deadline time.Time
+	waited   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.waited = true
+	t.clock.calls++
+	return t.Timer.C()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	delete(t.clock.timers, t)
+	t.clock.calls++
+	return t.Timer.Stop()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.deadline, t.waited = t.clock.Now().Add(d), false
+	t.clock.timers[t] = struct{}{}
+	t.clock.calls++
+	return t.Timer.Reset(d)
+}
+
+// step advances the fake clock by d once the electors wait on it, and waits
+// for them to react to the fired timers.
+func step(clk *fakeClock, d time.Duration) {
+	clk.settle()
+	clk.Step(d)
+	clk.settle()
+}
+
+// waitFor steps the fake clock by retryPeriod/4 until ch receives, and returns
+// the fake time elapsed.
+func waitFor(t *testing.T, clk *fakeClock, ch <-chan struct{}, max time.Duration) time.Duration {
+	t.Helper()
+	start := clk.Now()
+	for clk.Since(start) <= max {
+		select {
+		case <-ch:
+			return clk.Since(start)
+		default:
+		}
+		step(clk, retryPeriod/4)
+	}
+	t.Fatalf("nothing received after %v", max)
+	return 0
+}
+
+func TestClockRenew(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	e := newElector(t, ctx, s, clk, "a")
+	waitFor(t, clk, e.started, retryPeriod)
+	start := clk.Now()
+
+	for i := 0; i < 10*int(leaseDuration/retryPeriod); i++ {
+		step(clk, retryPeriod/2)
+	}
+	select {
+	case <-e.stopped:
+		t.Fatal("stopped leading")
+	default:
+	}
+	r, _ := s.Record("test")
+	if renewed := time.UnixMilli(r.RenewTime).Sub(start); renewed < 4*leaseDuration {
+		t.Errorf("lease last renewed %v after acquisition", renewed)
+	}
+	if err := e.Check(0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClockFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	a := newElector(t, ctx, s, clk, "a")
+	waitFor(t, clk, a.started, retryPeriod)
+	b := newElector(t, ctx, s, clk, "b")
+	step(clk, 0)
+
+	s.SetFaults("a", memory.Faults{ErrorRate: 1})
+	lost := waitFor(t, clk, a.stopped, 2*leaseDuration)
+	if lost < renewDeadline {
+		t.Errorf("a stopped leading after %v, before the renew deadline", lost)
+	}
+	if st := a.Status(); st.Leading || st.ExpiresIn == 0 {
+		t.Errorf("got status %+v after the renewal failed, want a lease not expired yet and not leading", st)
+	}
+	<-a.done
+
+	acquired := lost + waitFor(t, clk, b.started, 2*leaseDuration)
+	if acquired < leaseDuration {
+		t.Errorf("b started leading after %v, before the lease expiry", acquired)
+	}
+	if l := b.GetLeader(); l != "b" {
+		t.Errorf("got leader %q, want b", l)
+	}
+}
+
+func TestFencingToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	tokens := make(chan int64, 3)
+	withTokens := func(c *le.Config) {
+		started := c.Callbacks.OnStartedLeading
+		c.Callbacks.OnStartedLeading = func(ctx context.Context) {
+			token, ok := le.TokenFromContext(ctx)
+			if !ok {
+				t.Errorf("%s: no fencing token", c.Lock.Identity())
+			}
+			tokens <- token
+			started(ctx)
+		}
+	}
+	a := newElector(t, ctx, s, clk, "a", withTokens, func(c *le.Config) {
+		c.ResignCooldown = 2 * leaseDuration
+	})
+	waitFor(t, clk, a.started, retryPeriod)
+	bctx, bcancel := context.WithCancel(ctx)
+	b := newElector(t, bctx, s, clk, "b", withTokens, func(c *le.Config) {
+		c.ReleaseOnCancel = true
+	})
+	step(clk, 0)
+
+	// a hands over to b, which hands back to a
+	if err := a.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-a.stopped
+	waitFor(t, clk, b.started, leaseDuration)
+	bcancel()
+	<-b.done
+	waitFor(t, clk, a.started, 3*leaseDuration)
+
+	var last int64
+	for i := 0; i < 3; i++ {
+		token := <-tokens
+		if token <= last {
+			t.Errorf("got token %d in term %d, want more than %d", token, i+1, last)
+		}
+		last = token
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	a := newElector(t, ctx, s, clk, "a", func(c *le.Config) {
+		c.ReleaseOnCancel = true
+	})
+	waitFor(t, clk, a.started, retryPeriod)
+	b := newElector(t, ctx, s, clk, "b")
+	c := newElector(t, ctx, s, clk, "c")
+	step(clk, 0)
+
+	if err := b.Transfer(ctx, "c"); !errors.Is(err, le.ErrNotLeader) {
+		t.Errorf("got error %v for a transfer by a follower, want ErrNotLeader", err)
+	}
+	// c cannot acquire the lease before the handover record is checked
+	s.SetFaults("c", memory.Faults{ErrorRate: 1})
+	if err := a.Transfer(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if a.Status().Leading {
+		t.Error("a reported leading after the transfer")
+	}
+	<-a.stopped
+	<-a.done
+	if r, _ := s.Record("test"); r.HolderIdentity != "a" || r.TransferTo != "c" {
+		t.Fatalf("got record %+v after transfer", r)
+	}
+	s.SetFaults("c", memory.Faults{})
+	if acquired := waitFor(t, clk, c.started, leaseDuration); acquired >= leaseDuration {
+		t.Errorf("c started leading after %v, after the transfer window", acquired)
+	}
+	select {
+	case <-b.started:
+		t.Error("b started leading during the transfer")
+	default:
+	}
+	if r, _ := s.Record("test"); r.HolderIdentity != "c" || r.TransferTo != "" || r.LeaderTransitions != 1 {
+		t.Errorf("got record %+v after the successor acquired", r)
+	}
+}
+
+func TestTransferWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	window := func(c *le.Config) {
+		c.TransferWindow = 2 * leaseDuration
+	}
+	a := newElector(t, ctx, s, clk, "a", window)
+	waitFor(t, clk, a.started, retryPeriod)
+	b := newElector(t, ctx, s, clk, "b", window)
+	step(clk, 0)
+
+	if err := a.Transfer(ctx, "gone"); err != nil {
+		t.Fatal(err)
+	}
+	if acquired := waitFor(t, clk, b.started, 3*leaseDuration); acquired < 2*leaseDuration {
+		t.Errorf("b started leading after %v, during the transfer window", acquired)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	a := newElector(t, ctx, s, clk, "a", func(c *le.Config) {
+		c.Priority = 1
+	})
+	waitFor(t, clk, a.started, retryPeriod)
+	low := newElector(t, ctx, s, clk, "low")
+	step(clk, 0)
+	var priority atomic.Int64
+	b := newElector(t, ctx, s, clk, "b", func(c *le.Config) {
+		c.PriorityFunc = func() int {
+			return int(priority.Load())
+		}
+	})
+	// let b observe the lease without challenging
+	for i := 0; i < 4; i++ {
+		step(clk, retryPeriod)
+	}
+	select {
+	case <-a.stopped:
+		t.Fatal("a yielded to a candidate with a lower priority")
+	default:
+	}
+
+	priority.Store(2)
+	if yielded := waitFor(t, clk, a.stopped, leaseDuration); yielded >= leaseDuration {
+		t.Errorf("a yielded after %v, after its lease expiry", yielded)
+	}
+	waitFor(t, clk, b.started, leaseDuration)
+	select {
+	case <-low.started:
+		t.Error("a candidate with a lower priority started leading")
+	default:
+	}
+	if r, _ := s.Record("test"); r.HolderIdentity != "b" || r.HolderPriority != 2 || r.Challenger != "" {
+		t.Errorf("got record %+v after the preemption", r)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	a := newElector(t, ctx, s, clk, "a", func(c *le.Config) {
+		c.Metadata = map[string]string{"zone": "a"}
+	})
+	waitFor(t, clk, a.started, retryPeriod)
+	bctx, bcancel := context.WithCancel(ctx)
+	b := newElector(t, bctx, s, clk, "b")
+	newElector(t, ctx, s, clk, "c")
+
+	candidates := func() []string {
+		t.Helper()
+		cs, err := a.Candidates(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range cs {
+			got = append(got, c.Identity)
+		}
+		return got
+	}
+	assertCandidates := func(want ...string) {
+		t.Helper()
+		if got := candidates(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got candidates %v, want %v", got, want)
+		}
+	}
+	// b and c register on their first heartbeat
+	for start := clk.Now(); !reflect.DeepEqual(candidates(), []string{"a", "b", "c"}); {
+		if clk.Since(start) > retryPeriod {
+			t.Fatalf("got candidates %v, want [a b c]", candidates())
+		}
+		step(clk, retryPeriod/4)
+	}
+	if cs, _ := b.Candidates(ctx); cs[0].Metadata["zone"] != "a" {
+		t.Errorf("got candidate %+v, want zone metadata", cs[0])
+	}
+
+	bcancel()
+	<-b.done
+	assertCandidates("a", "c")
+
+	// c stops heartbeating
+	s.SetFaults("c", memory.Faults{ErrorRate: 1})
+	for i := 0; i < int(leaseDuration/retryPeriod)+1; i++ {
+		step(clk, retryPeriod)
+	}
+	assertCandidates("a")
+}
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	e, err := le.New(le.Config{
+		Lock:            memory.New(s, "test", "a"),
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		Clock:           clk,
+		ReleaseOnCancel: true,
+		Callbacks: le.Callbacks{
+			OnStartedLeading: func(context.Context) {},
+			OnStoppedLeading: func() {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, "stopped")
+			},
+			OnNewLeader: func(id string) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, "new leader "+id)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := e.Events()
+	if e.Events() != events {
+		t.Fatal("Events returned different channels")
+	}
+	rctx, rcancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(rctx)
+	}()
+
+	next := func(want le.EventType) le.Event {
+		t.Helper()
+		for {
+			select {
+			case ev := <-events:
+				if ev.Type == want {
+					return ev
+				}
+				if ev.Type != le.AcquireAttempt && ev.Type != le.Renewed {
+					t.Fatalf("got event %v, want %v", ev.Type, want)
+				}
+			case <-time.After(time.Second):
+				step(clk, retryPeriod)
+			}
+		}
+	}
+	if ev := next(le.AcquireAttempt); ev.Record.HolderIdentity != "" {
+		t.Errorf("got record %+v before the first acquisition", ev.Record)
+	}
+	if ev := next(le.NewLeaderObserved); ev.Record.HolderIdentity != "a" {
+		t.Errorf("got new leader %q, want a", ev.Record.HolderIdentity)
+	}
+	next(le.Acquired)
+	next(le.Renewed)
+
+	s.SetFaults("a", memory.Faults{ErrorRate: 1})
+	if ev := next(le.BackendError); !errors.Is(ev.Err, memory.ErrInjected) {
+		t.Errorf("got error %v, want ErrInjected", ev.Err)
+	}
+	s.SetFaults("a", memory.Faults{})
+
+	rcancel()
+	next(le.Released)
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"new leader a", "stopped"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("got callbacks %v, want %v", calls, want)
+	}
+}
+
+func TestEventsLost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	a := newElector(t, ctx, s, clk, "a")
+	waitFor(t, clk, a.started, retryPeriod)
+	events := a.Events()
+
+	s.SetFaults("a", memory.Faults{ErrorRate: 1})
+	waitFor(t, clk, a.stopped, 2*leaseDuration)
+	<-a.done
+	var got []le.EventType
+	for len(got) == 0 || got[len(got)-1] != le.Lost {
+		ev := <-events
+		switch ev.Type {
+		case le.RenewFailed, le.Lost:
+			got = append(got, ev.Type)
+		case le.Renewed, le.BackendError:
+		default:
+			t.Fatalf("got event %v while failing to renew", ev.Type)
+		}
+		if ev.Type == le.RenewFailed && ev.Err == nil {
+			t.Error("got RenewFailed event without error")
+		}
+	}
+	if want := []le.EventType{le.RenewFailed, le.Lost}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
+
+func TestResign(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	a := newElector(t, ctx, s, clk, "a", func(c *le.Config) {
+		c.ResignCooldown = 2 * leaseDuration
+	})
+	waitFor(t, clk, a.started, retryPeriod)
+	bctx, bcancel := context.WithCancel(ctx)
+	b := newElector(t, bctx, s, clk, "b", func(c *le.Config) {
+		c.ReleaseOnCancel = true
+	})
+	step(clk, 0)
+
+	if err := b.Resign(ctx); !errors.Is(err, le.ErrNotLeader) {
+		t.Errorf("got error %v for a follower resignation, want ErrNotLeader", err)
+	}
+	if err := a.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-a.stopped:
+	default:
+		t.Fatal("Resign returned before OnStoppedLeading")
+	}
+	if acquired := waitFor(t, clk, b.started, leaseDuration); acquired >= leaseDuration {
+		t.Errorf("b started leading after %v, after the resigned lease expiry", acquired)
+	}
+
+	// a campaigns again after its cooldown
+	bcancel()
+	<-b.done
+	if acquired := waitFor(t, clk, a.started, 3*leaseDuration); acquired < leaseDuration {
+		t.Errorf("a started leading again after %v, during its cooldown", acquired)
+	}
+	select {
+	case <-a.done:
+		t.Error("Run returned after Resign")
+	default:
+	}
+	if r, _ := s.Record("test"); r.HolderIdentity != "a" || r.LeaderTransitions != 2 {
+		t.Errorf("got record %+v after a campaigned again", r)
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	started := make(chan struct{}, 1)
+	var (
+		mu      sync.Mutex
+		leaders []string
+	)
+	e, err := le.New(le.Config{
+		Lock:          memory.New(s, "test", "a"),
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Clock:         clk,
+		Callbacks: le.Callbacks{
+			OnStartedLeading: func(context.Context) {
+				started <- struct{}{}
+			},
+			OnStoppedLeading: func() {},
+			OnNewLeader: func(identity string) {
+				mu.Lock()
+				defer mu.Unlock()
+				leaders = append(leaders, identity)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan le.StopReason, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Campaign(ctx, le.CampaignConfig{
+			MaxTerms: 3,
+			OnStoppedLeading: func(term int, reason le.StopReason) {
+				stopped <- reason
+			},
+		})
+	}()
+	wait := func(want le.StopReason) {
+		t.Helper()
+		for start := clk.Now(); clk.Since(start) <= 3*leaseDuration; step(clk, retryPeriod/4) {
+			select {
+			case got := <-stopped:
+				if got != want {
+					t.Errorf("term ended with %v, want %v", got, want)
+				}
+				return
+			default:
+			}
+		}
+		t.Fatalf("term did not end with %v after %v", want, 3*leaseDuration)
+	}
+
+	waitFor(t, clk, started, retryPeriod)
+	if err := e.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wait(le.StopResign)
+
+	// the backoff between the terms is the retry period, jittered by JitterFactor
+	waitFor(t, clk, started, 3*retryPeriod)
+	if err := s.Takeover("test", "x"); err != nil {
+		t.Fatal(err)
+	}
+	wait(le.StopConflict)
+
+	waitFor(t, clk, started, 2*leaseDuration)
+	s.SetFaults("a", memory.Faults{ErrorRate: 1})
+	wait(le.StopRenewDeadline)
+	<-done
+	if r, _ := s.Record("test"); r.LeaderTransitions != 3 {
+		t.Errorf("got %d leader transitions, want 3", r.LeaderTransitions)
+	}
+	// the leader changes are reported once, whatever the terms
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"a", "x", "a"}; !reflect.DeepEqual(leaders, want) {
+		t.Errorf("got new leaders %v, want %v", leaders, want)
+	}
+}
+
+func TestRunnables(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	started, stopped := make(chan struct{}, 1), make(chan struct{}, 1)
+	e, err := le.New(le.Config{
+		Lock:          memory.New(s, "test", "a"),
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Clock:         clk,
+		Callbacks: le.Callbacks{
+			OnStartedLeading: func(context.Context) {
+				started <- struct{}{}
+			},
+			OnStoppedLeading: func() {
+				stopped <- struct{}{}
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := make(chan string, 16)
+	component := func(name string) le.Runnable {
+		return le.RunnableFunc(func(ctx context.Context) error {
+			if _, ok := le.TokenFromContext(ctx); !ok {
+				t.Errorf("%s: no fencing token", name)
+			}
+			calls <- "start " + name
+			<-ctx.Done()
+			calls <- "stop " + name
+			return nil
+		})
+	}
+	e.AddRunnable(component("a"), le.RunnableOptions{Name: "a"})
+	var failures atomic.Int32
+	finish := make(chan struct{})
+	e.AddRunnable(le.RunnableFunc(func(ctx context.Context) error {
+		if failures.Add(1) < 3 {
+			return errors.New("failed")
+		}
+		select {
+		case <-finish:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}), le.RunnableOptions{Name: "critical", Restart: le.RestartOnFailure, Critical: true})
+	e.AddRunnable(component("b"), le.RunnableOptions{Name: "b"})
+	go e.Run(ctx)
+
+	waitFor(t, clk, started, retryPeriod)
+	// the runnables are started in order, but run concurrently
+	expectStarted := func() {
+		t.Helper()
+		got := map[string]bool{}
+		for len(got) < 2 {
+			select {
+			case c := <-calls:
+				got[c] = true
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for the runnables to start: %v", got)
+			}
+		}
+		if !got["start a"] || !got["start b"] {
+			t.Fatalf("got %v, want the runnables started", got)
+		}
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-calls:
+				if got != w {
+					t.Fatalf("got %q, want %q", got, w)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for %q", w)
+			}
+		}
+	}
+	expectStarted()
+	for i := 0; i < 4; i++ {
+		step(clk, retryPeriod)
+	}
+	if n := failures.Load(); n != 3 {
+		t.Errorf("critical runnable started %d times, want 3", n)
+	}
+
+	// the critical runnable finishing makes the elector resign
+	close(finish)
+	expect("stop b", "stop a")
+	<-stopped
+	if r, _ := s.Record("test"); r.LeaseDurationMilliSeconds != 1 {
+		t.Errorf("got record %+v, want a released lease", r)
+	}
+	waitFor(t, clk, started, 2*retryPeriod)
+	expectStarted()
+}
+
+func TestLeaderDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	leading := make(chan context.Context, 1)
+	newElector(t, ctx, s, clk, "a", func(c *le.Config) {
+		c.SafetyFraction = 0.5
+		c.Callbacks.OnStartedLeading = func(ctx context.Context) {
+			leading <- ctx
+		}
+	})
+	var lctx context.Context
+	waitFor(t, clk, func() <-chan struct{} {
+		ch := make(chan struct{})
+		go func() {
+			lctx = <-leading
+			close(ch)
+		}()
+		return ch
+	}(), retryPeriod)
+
+	window := leaseDuration / 2
+	renewed := func() time.Time {
+		r, _ := s.Record("test")
+		return time.UnixMilli(r.RenewTime)
+	}
+	// the record renew time is truncated to the millisecond
+	inWindow := func(d time.Time) bool {
+		w := d.Sub(renewed())
+		return w >= window && w < window+time.Millisecond
+	}
+	if d, ok := lctx.Deadline(); !ok || !inWindow(d) {
+		t.Errorf("got deadline %v, %v after the renewal, want %v", ok, d.Sub(renewed()), window)
+	}
+	for i := 0; i < 3; i++ {
+		step(clk, retryPeriod)
+	}
+	if d, _ := lctx.Deadline(); !inWindow(d) || !d.After(clk.Now()) {
+		t.Errorf("deadline %v not extended by the renewals", d)
+	}
+
+	s.SetFaults("a", memory.Faults{ErrorRate: 1})
+	last := renewed()
+	for lctx.Err() == nil {
+		step(clk, retryPeriod/4)
+	}
+	if !errors.Is(lctx.Err(), context.DeadlineExceeded) || !errors.Is(context.Cause(lctx), context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", lctx.Err())
+	}
+	if expired := clk.Since(last); expired > window+retryPeriod/4 {
+		t.Errorf("leader context expired %v after the last renewal, want %v", expired, window)
+	}
+}
+
+func TestOnChallenge(t *testing.T) {
+	type challenges chan le.Challenge
+	setup := func(t *testing.T, ctx context.Context, s *memory.Store, clk *fakeClock, id string, opts ...func(*le.Config)) (*elector, challenges) {
+		ch := make(challenges, 64)
+		e := newElector(t, ctx, s, clk, id, append(opts, func(c *le.Config) {
+			c.Callbacks.OnChallenge = func(c le.Challenge) {
+				ch <- c
+			}
+		})...)
+		return e, ch
+	}
+	expect := func(t *testing.T, clk *fakeClock, ch challenges, typ le.ChallengeType, contender string) {
+		t.Helper()
+		start := clk.Now()
+		for clk.Since(start) < 2*leaseDuration {
+			select {
+			case c := <-ch:
+				if c.Type != typ {
+					continue
+				}
+				if c.Contender != contender {
+					t.Errorf("got %v challenge by %q, want %q", c.Type, c.Contender, contender)
+				}
+				return
+			default:
+			}
+			step(clk, retryPeriod/4)
+		}
+		t.Fatalf("no %v challenge", typ)
+	}
+
+	t.Run("Contender", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := memory.NewStore()
+		clk := newFakeClock()
+		a, ch := setup(t, ctx, s, clk, "a")
+		waitFor(t, clk, a.started, retryPeriod)
+		newElector(t, ctx, s, clk, "b", func(c *le.Config) {
+			c.Priority = 1
+		})
+		expect(t, clk, ch, le.ChallengeContender, "b")
+	})
+	t.Run("ForeignWrite", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := memory.NewStore()
+		clk := newFakeClock()
+		a, ch := setup(t, ctx, s, clk, "a")
+		waitFor(t, clk, a.started, retryPeriod)
+		step(clk, retryPeriod)
+		select {
+		case c := <-ch:
+			t.Fatalf("got %v challenge without contender", c.Type)
+		default:
+		}
+		if err := s.Takeover("test", "x"); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, clk, ch, le.ChallengeForeignWrite, "x")
+	})
+	t.Run("Conflict", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := memory.NewStore()
+		clk := newFakeClock()
+		a, ch := setup(t, ctx, s, clk, "a")
+		waitFor(t, clk, a.started, retryPeriod)
+		s.ForceConflicts(1)
+		expect(t, clk, ch, le.ChallengeConflict, "")
+	})
+	t.Run("Stalled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := memory.NewStore()
+		clk := newFakeClock()
+		a := newElector(t, ctx, s, clk, "a")
+		waitFor(t, clk, a.started, retryPeriod)
+		_, ch := setup(t, ctx, s, clk, "b")
+		for i := 0; i < 2*int(renewDeadline/retryPeriod); i++ {
+			step(clk, retryPeriod)
+		}
+		select {
+		case c := <-ch:
+			t.Fatalf("got %v challenge while the leader renews", c.Type)
+		default:
+		}
+		s.SetFaults("a", memory.Faults{ErrorRate: 1})
+		expect(t, clk, ch, le.ChallengeStalled, "a")
+	})
+}
+
+// metrics is a le.ExtendedMetricsProvider recording the metrics of the
+// elector named "test".
+type metrics struct {
+	mu            sync.Mutex
+	leader        bool
+	latencies     map[string]int
+	transitions   int
+	renewFailures map[string]int
+	durations     map[string]time.Duration
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		latencies:     make(map[string]int),
+		renewFailures: make(map[string]int),
+		durations:     make(map[string]time.Duration),
+	}
+}
+
+func (m *metrics) NewLeaderMetric() le.SwitchMetric              { return metric{m, ""} }
+func (m *metrics) NewLatencyMetric() le.LatencyMetric            { return metric{m, ""} }
+func (m *metrics) NewTransitionsMetric() le.CounterMetric        { return metric{m, ""} }
+func (m *metrics) NewRenewFailuresMetric() le.ClassCounterMetric { return classMetric{m} }
+func (m *metrics) NewLeaseAgeMetric() le.DurationMetric          { return metric{m, "age"} }
+func (m *metrics) NewSinceRenewMetric() le.DurationMetric        { return metric{m, "renew"} }
+func (m *metrics) NewClockSkewMetric() le.DurationMetric         { return metric{m, "skew"} }
+
+func (m *metrics) get(f func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f()
+}
+
+type metric struct {
+	m   *metrics
+	key string
+}
+
+func (m metric) On(string) {
+	m.m.get(func() { m.m.leader = true })
+}
+
+func (m metric) Off(string) {
+	m.m.get(func() { m.m.leader = false })
+}
+
+func (m metric) Observe(_, phase, operation string, _ time.Duration) {
+	m.m.get(func() { m.m.latencies[phase+"/"+operation]++ })
+}
+
+func (m metric) Inc(string) {
+	m.m.get(func() { m.m.transitions++ })
+}
+
+func (m metric) Set(_ string, d time.Duration) {
+	m.m.get(func() { m.m.durations[m.key] = d })
+}
+
+type classMetric struct {
+	m *metrics
+}
+
+func (m classMetric) Inc(_, class string) {
+	m.m.get(func() { m.m.renewFailures[class]++ })
+}
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	ma, mb := newMetrics(), newMetrics()
+	a := newElector(t, ctx, s, clk, "a", func(c *le.Config) {
+		c.MetricsProvider = ma
+	})
+	waitFor(t, clk, a.started, retryPeriod)
+	// the provider is replaced, not only set once
+	le.SetProvider(newMetrics())
+	le.SetProvider(mb)
+	defer le.SetProvider(nil)
+	newElector(t, ctx, s, clk, "b")
+	for i := 0; i < 4; i++ {
+		step(clk, retryPeriod)
+	}
+
+	ma.get(func() {
+		if !ma.leader {
+			t.Error("leader metric is off while leading")
+		}
+		for _, k := range []string{"acquire/get", "acquire/create", "renew/get", "renew/update"} {
+			if ma.latencies[k] == 0 {
+				t.Errorf("no %s latency observed", k)
+			}
+		}
+		if d, ok := ma.durations["renew"]; !ok || d > retryPeriod {
+			t.Errorf("got %v since the last renewal", d)
+		}
+	})
+	mb.get(func() {
+		if mb.leader {
+			t.Error("leader metric is on while following")
+		}
+		if mb.transitions != 1 {
+			t.Errorf("got %d transitions, want 1", mb.transitions)
+		}
+		if d := mb.durations["age"]; d < 2*retryPeriod {
+			t.Errorf("got lease age %v, want at least %v", d, 2*retryPeriod)
+		}
+		if _, ok := mb.durations["skew"]; !ok {
+			t.Error("no clock skew observed")
+		}
+	})
+
+	s.SetFaults("a", memory.Faults{ErrorRate: 1})
+	waitFor(t, clk, a.stopped, 2*leaseDuration)
+	ma.get(func() {
+		if ma.leader {
+			t.Error("leader metric is on after losing the lease")
+		}
+		if want := map[string]int{"backend": 1}; !reflect.DeepEqual(ma.renewFailures, want) {
+			t.Errorf("got renew failures %v, want %v", ma.renewFailures, want)
+		}
+	})
+}
+
+// tracedLock is a Lock tracing its Get calls as a backend would.
+type tracedLock struct {
+	le.Lock
+}
+
+func (l tracedLock) Get(ctx context.Context) (*le.Record, []byte, le.Version, error) {
+	ctx, span := le.StartSpan(ctx, "memory.Get")
+	r, raw, v, err := l.Lock.Get(ctx)
+	le.EndSpan(span, err)
+	return r, raw, v, err
+}
+
+func TestTracing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	sr := tracetest.NewSpanRecorder()
+	a := newElector(t, ctx, s, clk, "a", func(c *le.Config) {
+		c.Lock = tracedLock{c.Lock}
+		c.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	})
+	waitFor(t, clk, a.started, retryPeriod)
+	step(clk, retryPeriod)
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, s := range sr.Ended() {
+		spans[s.Name()] = append(spans[s.Name()], s)
+	}
+	attrs := func(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range s.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	parents := make(map[string]string)
+	for _, s := range sr.Ended() {
+		for _, p := range sr.Ended() {
+			if s.Parent().SpanID() == p.SpanContext().SpanID() {
+				parents[s.Name()] = p.Name()
+			}
+		}
+	}
+	for name, parent := range map[string]string{
+		"leaderelection.get":    "leaderelection.tryAcquireOrRenew",
+		"leaderelection.create": "leaderelection.tryAcquireOrRenew",
+		"leaderelection.update": "leaderelection.tryAcquireOrRenew",
+		"memory.Get":            "leaderelection.get",
+	} {
+		if parents[name] != parent {
+			t.Errorf("got %s span parent %q, want %q", name, parents[name], parent)
+		}
+	}
+
+	get := spans["leaderelection.get"]
+	if len(get) < 2 {
+		t.Fatalf("got %d get spans, want at least 2", len(get))
+	}
+	first, last := attrs(get[0]), attrs(get[len(get)-1])
+	for k, v := range map[string]string{
+		"leaderelection.name":     "test",
+		"leaderelection.identity": "a",
+		"leaderelection.lock":     "memory/test",
+		"leaderelection.phase":    "acquire",
+		"leaderelection.outcome":  "not_found",
+	} {
+		if got := first[attribute.Key(k)].Emit(); got != v {
+			t.Errorf("got first get span %s %q, want %q", k, got, v)
+		}
+	}
+	if got := last[attribute.Key("leaderelection.phase")].Emit(); got != "renew" {
+		t.Errorf("got last get span phase %q, want renew", got)
+	}
+	if got := last[attribute.Key("leaderelection.outcome")].Emit(); got != "success" {
+		t.Errorf("got last get span outcome %q, want success", got)
+	}
+}
+
+// loggedLock is a Lock logging its Get calls and its events as a backend
+// would.
+type loggedLock struct {
+	le.Lock
+	log logr.Logger
+}
+
+func (l *loggedLock) Get(ctx context.Context) (*le.Record, []byte, le.Version, error) {
+	klog.FromContext(ctx).Info("memory.Get")
+	return l.Lock.Get(ctx)
+}
+
+func (l *loggedLock) RecordEvent(s string) {
+	l.log.Info("lock event", "event", s)
+}
+
+func (l *loggedLock) SetLogger(log logr.Logger) {
+	l.log = log
+}
+
+func TestLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	a := newElector(t, ctx, s, clk, "a", func(c *le.Config) {
+		c.Lock = &loggedLock{Lock: c.Lock, log: logr.Discard()}
+		c.Logger = funcr.New(func(_, args string) {
+			mu.Lock()
+			defer mu.Unlock()
+			lines = append(lines, args)
+		}, funcr.Options{})
+	})
+	waitFor(t, clk, a.started, retryPeriod)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, msg := range []string{"successfully acquired lease", "memory.Get", "lock event"} {
+		var line string
+		for _, l := range lines {
+			if strings.Contains(l, `"msg"="`+msg+`"`) {
+				line = l
+			}
+		}
+		if line == "" {
+			t.Errorf("no %q line logged in %q", msg, lines)
+			continue
+		}
+		for _, kv := range []string{`"name"="test"`, `"lock"="memory/test"`, `"identity"="a"`} {
+			if !strings.Contains(line, kv) {
+				t.Errorf("got %q line %s, want %s", msg, line, kv)
+			}
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	a := newElector(t, ctx, s, clk, "a")
+	waitFor(t, clk, a.started, retryPeriod)
+	b := newElector(t, ctx, s, clk, "b")
+	step(clk, 0)
+
+	get := func(h http.Handler) (int, le.HealthzStatus) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		var s le.HealthzStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, s
+	}
+	ha, hb := le.NewHealthzAdaptor(0, leaseDuration), le.NewHealthzAdaptor(0, leaseDuration)
+	if code, _ := get(ha.ReadyzHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("got readyz status %d before SetLeaderElection, want 503", code)
+	}
+	ha.SetLeaderElection(a.LeaderElector)
+	hb.SetLeaderElection(b.LeaderElector)
+
+	if code, st := get(ha.ReadyzHandler()); code != http.StatusOK || st.Status != "ok" || st.Identity != "a" || st.Leader != "a" {
+		t.Errorf("got leader readyz %d %+v", code, st)
+	}
+	code, st := get(hb.ReadyzHandler())
+	if code != http.StatusServiceUnavailable || st.Status != "failed" || len(st.Checks) != 1 || st.Checks[0].Name != "leader" || st.Checks[0].Error == "" {
+		t.Errorf("got follower readyz %d %+v", code, st)
+	}
+	if code, st := get(hb.HealthzHandler()); code != http.StatusOK || len(st.Checks) != 2 {
+		t.Errorf("got follower healthz %d %+v", code, st)
+	}
+
+	// b can no longer reach the lock
+	s.SetFaults("b", memory.Faults{ErrorRate: 1})
+	for i := 0; i < int(leaseDuration/retryPeriod)-2; i++ {
+		step(clk, retryPeriod)
+	}
+	// b last reached the lock less than a jittered retryPeriod before
+	if err := hb.FollowerCheck(nil); err != nil {
+		t.Errorf("follower check failed before the threshold: %v", err)
+	}
+	step(clk, 3*retryPeriod)
+	code, st = get(hb.HealthzHandler())
+	if code != http.StatusServiceUnavailable || st.Checks[0].Status != "ok" || st.Checks[1].Name != "follower" || st.Checks[1].Status != "failed" {
+		t.Errorf("got unreachable follower healthz %d %+v", code, st)
+	}
+	if code, _ := get(hb.LivezHandler()); code != http.StatusOK {
+		t.Errorf("got unreachable follower livez %d, want 200", code)
+	}
+	if err := ha.FollowerCheck(nil); err != nil {
+		t.Errorf("leader follower check failed: %v", err)
+	}
+
+	s.SetFaults("b", memory.Faults{})
+	start := clk.Now()
+	for hb.FollowerCheck(nil) != nil {
+		if clk.Since(start) > 2*retryPeriod {
+			t.Fatal("follower check still failing after reaching the lock again")
+		}
+		step(clk, retryPeriod/4)
+	}
+}
@@ -0,0 +1,518 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package leaderelectiontest provides utilities for testing le.Lock implementations.
+package leaderelectiontest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	le "go.linka.cloud/leaderelection"
+)
+
+// Factory returns a Lock on the record called name, for the given identity.
+// The Locks returned for the same name must share the same record, while the
+// records of different names must be independent.
+// Names are lower case alphanumeric strings, possibly containing dashes.
+type Factory func(t *testing.T, name, identity string) le.Lock
+
+// RunLockConformance runs the tests checking that the Locks returned by factory
+// behave the way the LeaderElector expects.
+func RunLockConformance(t *testing.T, factory Factory) {
+	t.Run("Identity", func(t *testing.T) {
+		testIdentity(t, factory)
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		testNotFound(t, factory)
+	})
+	t.Run("CreateGet", func(t *testing.T) {
+		testCreateGet(t, factory)
+	})
+	t.Run("CreateConflict", func(t *testing.T) {
+		testCreateConflict(t, factory)
+	})
+	t.Run("Update", func(t *testing.T) {
+		testUpdate(t, factory)
+	})
+	t.Run("UpdateConflict", func(t *testing.T) {
+		testUpdateConflict(t, factory)
+	})
+	t.Run("Contenders", func(t *testing.T) {
+		testContenders(t, factory)
+	})
+	t.Run("Release", func(t *testing.T) {
+		testRelease(t, factory)
+	})
+	t.Run("Transfer", func(t *testing.T) {
+		testTransfer(t, factory)
+	})
+	t.Run("Challenge", func(t *testing.T) {
+		testChallenge(t, factory)
+	})
+	t.Run("Holder", func(t *testing.T) {
+		testHolder(t, factory)
+	})
+	t.Run("Candidates", func(t *testing.T) {
+		testCandidates(t, factory)
+	})
+}
+
+func testIdentity(t *testing.T, factory Factory) {
+	l := factory(t, "conformance-identity", "candidate-a")
+	if got := l.Identity(); got != "candidate-a" {
+		t.Errorf("Identity() = %q, want %q", got, "candidate-a")
+	}
+	if l.Describe() == "" {
+		t.Error("Describe() must not be empty")
+	}
+	if c := l.Consistency(); c != le.BestEffort && c != le.CompareAndSwap {
+		t.Errorf("Consistency() = %v, want %v or %v", c, le.BestEffort, le.CompareAndSwap)
+	}
+	// the elector records events before having read the record
+	l.RecordEvent("conformance")
+}
+
+func testNotFound(t *testing.T, factory Factory) {
+	l := factory(t, "conformance-not-found", "candidate-a")
+	ler, _, _, err := l.Get(ctx(t))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Get() on a missing record: got error %v, want os.ErrNotExist", err)
+	}
+	if ler != nil {
+		t.Errorf("Get() on a missing record: got %+v, want nil", ler)
+	}
+}
+
+func testCreateGet(t *testing.T, factory Factory) {
+	a := factory(t, "conformance-create-get", "candidate-a")
+	b := factory(t, "conformance-create-get", "candidate-b")
+	want := record(a.Identity(), 1)
+	v, err := a.Create(ctx(t), want)
+	if err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	if v == "" {
+		t.Error("Create() returned an empty version")
+	}
+	for _, l := range []le.Lock{a, b} {
+		got, raw, gv, err := l.Get(ctx(t))
+		if err != nil {
+			t.Fatalf("%s: Get(): %v", l.Identity(), err)
+		}
+		assertRecord(t, got, want)
+		if len(raw) == 0 {
+			t.Errorf("%s: Get() returned an empty raw record", l.Identity())
+		}
+		if gv != v {
+			t.Errorf("%s: Get() version = %q, want %q", l.Identity(), gv, v)
+		}
+	}
+}
+
+func testCreateConflict(t *testing.T, factory Factory) {
+	a := factory(t, "conformance-create-conflict", "candidate-a")
+	b := factory(t, "conformance-create-conflict", "candidate-b")
+	if _, err := a.Create(ctx(t), record(a.Identity(), 1)); err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	for _, l := range []le.Lock{a, b} {
+		_, err := l.Create(ctx(t), record(l.Identity(), 2))
+		if !errors.Is(err, le.ErrConflict) {
+			t.Errorf("%s: Create() on an existing record: got error %v, want le.ErrConflict", l.Identity(), err)
+		}
+	}
+	got, _, _, err := b.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	if got.HolderIdentity != a.Identity() {
+		t.Errorf("record overwritten by a conflicting Create(): holder is %q", got.HolderIdentity)
+	}
+}
+
+func testUpdate(t *testing.T, factory Factory) {
+	a := factory(t, "conformance-update", "candidate-a")
+	b := factory(t, "conformance-update", "candidate-b")
+	ler := record(a.Identity(), 1)
+	if _, err := a.Create(ctx(t), ler); err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	_, raw, v, err := a.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	// the raw record must be stable as long as the record does not change:
+	// the elector uses its changes to detect the holder renewals
+	_, raw2, v2, err := b.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	if !bytes.Equal(raw, raw2) || v != v2 {
+		t.Errorf("Get() of an unchanged record returned different raw records or versions: %q/%q, %q/%q", raw, raw2, v, v2)
+	}
+	for i := 0; i < 3; i++ {
+		ler = renew(ler)
+		nv, err := a.Update(ctx(t), ler, v)
+		if err != nil {
+			t.Fatalf("renew %d: Update(): %v", i, err)
+		}
+		if nv == v {
+			t.Errorf("renew %d: Update() did not change the version", i)
+		}
+		got, nraw, gv, err := b.Get(ctx(t))
+		if err != nil {
+			t.Fatalf("renew %d: Get(): %v", i, err)
+		}
+		assertRecord(t, got, ler)
+		if gv != nv {
+			t.Errorf("renew %d: Get() version = %q, want %q", i, gv, nv)
+		}
+		if bytes.Equal(raw, nraw) {
+			t.Errorf("renew %d: the raw record did not change", i)
+		}
+		raw, v = nraw, nv
+	}
+}
+
+func testUpdateConflict(t *testing.T, factory Factory) {
+	a := factory(t, "conformance-update-conflict", "candidate-a")
+	b := factory(t, "conformance-update-conflict", "candidate-b")
+	ler := record(a.Identity(), 1)
+	v, err := a.Create(ctx(t), ler)
+	if err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	if _, _, _, err := b.Get(ctx(t)); err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	if _, err := a.Update(ctx(t), renew(ler), v); err != nil {
+		t.Fatalf("Update(): %v", err)
+	}
+	// b still holds the version it read before the renewal
+	if _, err := b.Update(ctx(t), record(b.Identity(), 2), v); !errors.Is(err, le.ErrConflict) {
+		t.Fatalf("Update() with a stale version: got error %v, want le.ErrConflict", err)
+	}
+	got, _, _, err := b.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	if got.HolderIdentity != a.Identity() {
+		t.Errorf("record overwritten by an Update() with a stale version: holder is %q", got.HolderIdentity)
+	}
+}
+
+func testContenders(t *testing.T, factory Factory) {
+	const n = 5
+	var locks []le.Lock
+	for i := 0; i < n; i++ {
+		locks = append(locks, factory(t, "conformance-contenders", fmt.Sprintf("candidate-%d", i)))
+	}
+	if _, err := locks[0].Create(ctx(t), record(locks[0].Identity(), 1)); err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	versions := make([]le.Version, n)
+	for i, l := range locks {
+		_, _, v, err := l.Get(ctx(t))
+		if err != nil {
+			t.Fatalf("%s: Get(): %v", l.Identity(), err)
+		}
+		versions[i] = v
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		winners = map[string]bool{}
+		c       = ctx(t)
+	)
+	for i, l := range locks {
+		wg.Add(1)
+		go func(l le.Lock, v le.Version) {
+			defer wg.Done()
+			_, err := l.Update(c, record(l.Identity(), 2), v)
+			if err != nil {
+				if !errors.Is(err, le.ErrConflict) {
+					t.Errorf("%s: concurrent Update(): got error %v, want le.ErrConflict", l.Identity(), err)
+				}
+				return
+			}
+			mu.Lock()
+			winners[l.Identity()] = true
+			mu.Unlock()
+		}(l, versions[i])
+	}
+	wg.Wait()
+	if len(winners) == 0 {
+		t.Fatal("no concurrent Update() succeeded")
+	}
+	if locks[0].Consistency() == le.CompareAndSwap && len(winners) != 1 {
+		t.Errorf("%d concurrent Update() succeeded on a compare-and-swap lock, want 1", len(winners))
+	}
+	got, _, _, err := locks[0].Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	if !winners[got.HolderIdentity] {
+		t.Errorf("record holder %q is not one of the successful contenders", got.HolderIdentity)
+	}
+}
+
+func testRelease(t *testing.T, factory Factory) {
+	a := factory(t, "conformance-release", "candidate-a")
+	b := factory(t, "conformance-release", "candidate-b")
+	ler := record(a.Identity(), 1)
+	v, err := a.Create(ctx(t), ler)
+	if err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	// release the way the elector does: keep the holder and shorten the lease
+	ler = renew(ler)
+	ler.LeaseDurationMilliSeconds = 1
+	v, err = a.Update(ctx(t), ler, v)
+	if err != nil {
+		t.Fatalf("release: Update(): %v", err)
+	}
+	got, _, bv, err := b.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	if got.HolderIdentity != a.Identity() || got.LeaderTransitions != ler.LeaderTransitions {
+		t.Fatalf("released record: got holder %q and transitions %d, want %q and %d", got.HolderIdentity, got.LeaderTransitions, a.Identity(), ler.LeaderTransitions)
+	}
+	if got.LeaseDurationMilliSeconds > int((time.Second).Milliseconds()) {
+		t.Errorf("released record: got a lease duration of %dms", got.LeaseDurationMilliSeconds)
+	}
+	if _, err := b.Update(ctx(t), record(b.Identity(), got.LeaderTransitions+1), bv); err != nil {
+		t.Fatalf("acquire after release: Update(): %v", err)
+	}
+	if _, err := a.Update(ctx(t), renew(ler), v); !errors.Is(err, le.ErrConflict) {
+		t.Errorf("renew after losing the record: got error %v, want le.ErrConflict", err)
+	}
+}
+
+func testTransfer(t *testing.T, factory Factory) {
+	a := factory(t, "conformance-transfer", "candidate-a")
+	b := factory(t, "conformance-transfer", "candidate-b")
+	ler := record(a.Identity(), 1)
+	v, err := a.Create(ctx(t), ler)
+	if err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	ler = renew(ler)
+	ler.LeaseDurationMilliSeconds = int((10 * time.Second).Milliseconds())
+	ler.TransferTo = b.Identity()
+	if _, err := a.Update(ctx(t), ler, v); err != nil {
+		t.Fatalf("transfer: Update(): %v", err)
+	}
+	got, _, bv, err := b.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	assertRecord(t, got, ler)
+	// the successor acquires, clearing the transfer
+	ler = record(b.Identity(), 2)
+	if _, err := b.Update(ctx(t), ler, bv); err != nil {
+		t.Fatalf("acquire after transfer: Update(): %v", err)
+	}
+	got, _, _, err = a.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	assertRecord(t, got, ler)
+}
+
+func testChallenge(t *testing.T, factory Factory) {
+	a := factory(t, "conformance-challenge", "candidate-a")
+	b := factory(t, "conformance-challenge", "candidate-b")
+	ler := record(a.Identity(), 1)
+	ler.HolderPriority = 1
+	if _, err := a.Create(ctx(t), ler); err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	got, _, bv, err := b.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	assertRecord(t, got, ler)
+	// the challenger publishes its priority, leaving the rest of the record untouched
+	ler.Challenger = b.Identity()
+	ler.ChallengerPriority = 2
+	if _, err := b.Update(ctx(t), ler, bv); err != nil {
+		t.Fatalf("challenge: Update(): %v", err)
+	}
+	got, _, _, err = a.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	assertRecord(t, got, ler)
+}
+
+func testHolder(t *testing.T, factory Factory) {
+	a := factory(t, "conformance-holder", "candidate-a")
+	b := factory(t, "conformance-holder", "candidate-b")
+	ler := record(a.Identity(), 1)
+	ler.HolderAddress = "http://10.0.0.1:8080"
+	ler.HolderMetadata = map[string]string{"zone": "eu-west-1a", "version": "v1.2.3"}
+	v, err := a.Create(ctx(t), ler)
+	if err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	got, _, _, err := b.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	assertRecord(t, got, ler)
+	// the holder fields are cleared with the record
+	ler = renew(ler)
+	ler.HolderAddress, ler.HolderMetadata = "", nil
+	if _, err := a.Update(ctx(t), ler, v); err != nil {
+		t.Fatalf("Update(): %v", err)
+	}
+	got, _, _, err = b.Get(ctx(t))
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	assertRecord(t, got, ler)
+}
+
+// testCandidates checks the le.CandidateRegistry implementation, if any.
+func testCandidates(t *testing.T, factory Factory) {
+	var rs []le.CandidateRegistry
+	for _, id := range []string{"candidate-a", "candidate-b"} {
+		r, ok := factory(t, "conformance-candidates", id).(le.CandidateRegistry)
+		if !ok {
+			t.Skip("the lock does not implement le.CandidateRegistry")
+		}
+		rs = append(rs, r)
+	}
+	other := factory(t, "conformance-candidates-other", "candidate-c").(le.CandidateRegistry)
+	cc := candidate("candidate-c", nil)
+	if err := other.Register(ctx(t), cc); err != nil {
+		t.Fatalf("Register(): %v", err)
+	}
+
+	a, b := rs[0], rs[1]
+	if err := a.Unregister(ctx(t)); err != nil {
+		t.Errorf("Unregister() of a missing candidate: %v", err)
+	}
+	ca := candidate("candidate-a", map[string]string{"zone": "a"})
+	cb := candidate("candidate-b", nil)
+	if err := a.Register(ctx(t), ca); err != nil {
+		t.Fatalf("Register(): %v", err)
+	}
+	if err := b.Register(ctx(t), cb); err != nil {
+		t.Fatalf("Register(): %v", err)
+	}
+	assertCandidates(t, a, ca, cb)
+	// heartbeat
+	ca.LastSeen = ca.LastSeen.Add(time.Second)
+	ca.Metadata = map[string]string{"zone": "b"}
+	if err := a.Register(ctx(t), ca); err != nil {
+		t.Fatalf("Register(): %v", err)
+	}
+	assertCandidates(t, b, ca, cb)
+	if err := b.Unregister(ctx(t)); err != nil {
+		t.Fatalf("Unregister(): %v", err)
+	}
+	assertCandidates(t, a, ca)
+	assertCandidates(t, other, cc)
+
+	// the heartbeats do not conflict with the updates of the record
+	l := a.(le.Lock)
+	v, err := l.Create(ctx(t), record(l.Identity(), 1))
+	if err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	if err := b.Register(ctx(t), cb); err != nil {
+		t.Fatalf("Register(): %v", err)
+	}
+	if _, err := l.Update(ctx(t), record(l.Identity(), 2), v); err != nil {
+		t.Errorf("Update() after a heartbeat: %v", err)
+	}
+}
+
+func candidate(id string, md map[string]string) le.Candidate {
+	return le.Candidate{Identity: id, Metadata: md, LastSeen: time.Now().Truncate(time.Millisecond)}
+}
+
+func assertCandidates(t *testing.T, r le.CandidateRegistry, want ...le.Candidate) {
+	t.Helper()
+	got, err := r.Candidates(ctx(t))
+	if err != nil {
+		t.Fatalf("Candidates(): %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got candidates %+v, want %+v", got, want)
+	}
+	for _, w := range want {
+		found := false
+		for _, g := range got {
+			if g.Identity != w.Identity {
+				continue
+			}
+			found = true
+			if !g.LastSeen.Equal(w.LastSeen) || len(g.Metadata) != len(w.Metadata) || (len(w.Metadata) > 0 && !reflect.DeepEqual(g.Metadata, w.Metadata)) {
+				t.Errorf("got candidate %+v, want %+v", g, w)
+			}
+		}
+		if !found {
+			t.Errorf("candidate %s not found in %+v", w.Identity, got)
+		}
+	}
+}
+
+func ctx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func record(id string, transitions int) le.Record {
+	now := time.Now().Truncate(time.Millisecond)
+	return le.Record{
+		Version:                   le.RecordVersion,
+		HolderIdentity:            id,
+		LeaseDurationMilliSeconds: int((15 * time.Second).Milliseconds()),
+		AcquireTime:               now.UnixMilli(),
+		RenewTime:                 now.UnixMilli(),
+		LeaderTransitions:         transitions,
+	}
+}
+
+func renew(ler le.Record) le.Record {
+	// make sure the renew time moves even on coarse clocks
+	now := time.Now().UnixMilli()
+	if now <= ler.RenewTime {
+		now = ler.RenewTime + 1
+	}
+	ler.RenewTime = now
+	return ler
+}
+
+func assertRecord(t *testing.T, got *le.Record, want le.Record) {
+	t.Helper()
+	if got == nil {
+		t.Fatal("got a nil record")
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("got record %+v, want %+v", *got, want)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// leaseContext is a context whose deadline is pushed back each time the lease
+// is renewed. Once the deadline passed, it is cancelled with
+// context.DeadlineExceeded as cause.
+type leaseContext struct {
+	context.Context
+	cancel context.CancelCauseFunc
+	clock  clock.Clock
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func newLeaseContext(parent context.Context, c clock.Clock, deadline time.Time) *leaseContext {
+	ctx, cancel := context.WithCancelCause(parent)
+	l := &leaseContext{Context: ctx, cancel: cancel, clock: c, deadline: deadline}
+	t := c.NewTimer(deadline.Sub(c.Now()))
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C():
+			}
+			// the deadline may have been extended since the timer was set
+			d, _ := l.Deadline()
+			if left := d.Sub(c.Now()); left > 0 {
+				t.Reset(left)
+				continue
+			}
+			cancel(context.DeadlineExceeded)
+			return
+		}
+	}()
+	return l
+}
+
+// extend pushes the deadline back to the given time.
+func (l *leaseContext) extend(deadline time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if deadline.After(l.deadline) {
+		l.deadline = deadline
+	}
+}
+
+func (l *leaseContext) Deadline() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if d, ok := l.Context.Deadline(); ok && d.Before(l.deadline) {
+		return d, true
+	}
+	return l.deadline, true
+}
+
+func (l *leaseContext) Err() error {
+	err := l.Context.Err()
+	if err != nil && errors.Is(context.Cause(l.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
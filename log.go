@@ -15,11 +15,24 @@
 package leaderelection
 
 import (
-	"github.com/bombsimon/logrusr/v4"
-	"github.com/sirupsen/logrus"
+	"github.com/go-logr/logr"
 	"k8s.io/klog/v2"
 )
 
-func init() {
-	klog.SetLogger(logrusr.New(logrus.StandardLogger()))
+// defaultLogger returns l, defaulting to the klog logger if unset.
+func defaultLogger(l logr.Logger) logr.Logger {
+	if l.GetSink() == nil {
+		return klog.Background()
+	}
+	return l
+}
+
+// newLogger returns l, defaulting to the klog logger, with the name of the
+// election and the lock description and identity as values.
+func newLogger(l logr.Logger, name string, lock Lock) logr.Logger {
+	l = defaultLogger(l)
+	if name != "" {
+		l = l.WithValues("name", name)
+	}
+	return l.WithValues("lock", lock.Describe(), "identity", lock.Identity())
 }
@@ -0,0 +1,338 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memory implements an in-process lock backend, meant for testing code
+// guarded by the leader election without any external dependency.
+//
+// All the Locks created from the same Store share its records, so that several
+// LeaderElectors can run in the same test binary, and the Store can inject faults
+// in their operations.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	le "go.linka.cloud/leaderelection"
+)
+
+// ErrInjected is returned by the operations failed by the Faults error rate.
+var ErrInjected = errors.New("memory: injected fault")
+
+var (
+	_ le.Lock              = (*Lock)(nil)
+	_ le.CandidateRegistry = (*Lock)(nil)
+)
+
+// Faults describes the faults injected in the operations of a Lock.
+type Faults struct {
+	// GetLatency is added to each Get.
+	GetLatency time.Duration
+	// UpdateLatency is added to each Create and Update.
+	UpdateLatency time.Duration
+	// ErrorRate is the probability, between 0 and 1, for an operation to
+	// fail with ErrInjected.
+	ErrorRate float64
+}
+
+type entry struct {
+	record  le.Record
+	raw     []byte
+	version le.Version
+}
+
+// Store holds the records shared by its Locks.
+type Store struct {
+	mu      sync.Mutex
+	records map[string]*entry
+	// candidates holds the candidates registered on each record
+	candidates map[string]map[string]le.Candidate
+	// partitions holds the records as seen by the partitioned identities
+	partitions map[string]map[string]*entry
+	faults     map[string]Faults
+	conflicts  int
+	version    uint64
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{
+		records:    make(map[string]*entry),
+		candidates: make(map[string]map[string]le.Candidate),
+		partitions: make(map[string]map[string]*entry),
+		faults:     make(map[string]Faults),
+	}
+}
+
+// New returns a Lock on the record called name for the given identity.
+func New(s *Store, name, id string) *Lock {
+	return &Lock{store: s, name: name, id: id}
+}
+
+// SetFaults injects faults in the operations of the Locks of the given identity.
+// The faults of the empty identity apply to the identities without faults of their own.
+func (s *Store) SetFaults(id string, f Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[id] = f
+}
+
+// ForceConflicts makes the next n Create and Update calls fail with a *le.ConflictError.
+func (s *Store) ForceConflicts(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conflicts = n
+}
+
+// Partition isolates the given identity: its writes keep succeeding from its
+// point of view, but are hidden from the other identities until Heal is called.
+func (s *Store) Partition(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.partitions[id]; !ok {
+		s.partitions[id] = make(map[string]*entry)
+	}
+}
+
+// Heal ends the partition of the given identity, discarding its hidden writes.
+func (s *Store) Heal(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.partitions, id)
+}
+
+// Takeover makes id the holder of the record called name, starting a new
+// leadership term, whatever the state of the current lease.
+func (s *Store) Takeover(name, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UnixMilli()
+	ler := le.Record{
+		HolderIdentity:            id,
+		LeaseDurationMilliSeconds: int((15 * time.Second).Milliseconds()),
+		AcquireTime:               now,
+		RenewTime:                 now,
+	}
+	if e, ok := s.records[name]; ok {
+		ler.LeaseDurationMilliSeconds = e.record.LeaseDurationMilliSeconds
+		ler.LeaderTransitions = e.record.LeaderTransitions + 1
+	}
+	e, err := s.newEntry(ler)
+	if err != nil {
+		return err
+	}
+	s.records[name] = e
+	return nil
+}
+
+// Record returns the record called name, as seen by the identities which are
+// not partitioned.
+func (s *Store) Record(name string) (le.Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.records[name]
+	if !ok {
+		return le.Record{}, false
+	}
+	return e.record, true
+}
+
+func (s *Store) newEntry(ler le.Record) (*entry, error) {
+	b, err := json.Marshal(ler)
+	if err != nil {
+		return nil, err
+	}
+	s.version++
+	return &entry{record: ler, raw: b, version: le.Version(strconv.FormatUint(s.version, 10))}, nil
+}
+
+// lookup returns the record called name as seen by the given identity.
+// It must be called with the lock held.
+func (s *Store) lookup(id, name string) (*entry, bool) {
+	if p, ok := s.partitions[id]; ok {
+		if e, ok := p[name]; ok {
+			return e, true
+		}
+	}
+	e, ok := s.records[name]
+	return e, ok
+}
+
+// put stores the record called name, hiding it from the other identities if
+// the given identity is partitioned.
+// It must be called with the lock held.
+func (s *Store) put(id, name string, e *entry) {
+	if p, ok := s.partitions[id]; ok {
+		p[name] = e
+		return
+	}
+	s.records[name] = e
+}
+
+// inject applies the faults of the given identity: it waits for the latency
+// and returns ErrInjected according to the error rate.
+func (s *Store) inject(ctx context.Context, id string, latency func(f Faults) time.Duration) error {
+	s.mu.Lock()
+	f, ok := s.faults[id]
+	if !ok {
+		f = s.faults[""]
+	}
+	s.mu.Unlock()
+	if d := latency(f); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+		return ErrInjected
+	}
+	return nil
+}
+
+// Lock is a le.Lock on a record of a Store.
+type Lock struct {
+	store *Store
+	name  string
+	id    string
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *Lock) Get(ctx context.Context) (*le.Record, []byte, le.Version, error) {
+	if err := l.store.inject(ctx, l.id, func(f Faults) time.Duration { return f.GetLatency }); err != nil {
+		return nil, nil, "", err
+	}
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	e, ok := l.store.lookup(l.id, l.name)
+	if !ok {
+		return nil, nil, "", fmt.Errorf("%s: %w", l.name, os.ErrNotExist)
+	}
+	ler := e.record
+	return &ler, e.raw, e.version, nil
+}
+
+func (l *Lock) Create(ctx context.Context, ler le.Record) (le.Version, error) {
+	return l.set(ctx, ler, "", true)
+}
+
+func (l *Lock) Update(ctx context.Context, ler le.Record, version le.Version) (le.Version, error) {
+	return l.set(ctx, ler, version, false)
+}
+
+// Consistency returns le.CompareAndSwap: the records are swapped under the Store lock.
+func (l *Lock) Consistency() le.Consistency {
+	return le.CompareAndSwap
+}
+
+// RecordEvent records the event, see Events.
+func (l *Lock) RecordEvent(s string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, s)
+}
+
+// Events returns the events recorded by the Lock.
+func (l *Lock) Events() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+// Register registers the candidate on the record. The candidates are not
+// affected by the partitions.
+func (l *Lock) Register(ctx context.Context, c le.Candidate) error {
+	if err := l.store.inject(ctx, l.id, func(f Faults) time.Duration { return f.UpdateLatency }); err != nil {
+		return err
+	}
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	cs, ok := l.store.candidates[l.name]
+	if !ok {
+		cs = make(map[string]le.Candidate)
+		l.store.candidates[l.name] = cs
+	}
+	cs[l.id] = c
+	return nil
+}
+
+func (l *Lock) Unregister(ctx context.Context) error {
+	if err := l.store.inject(ctx, l.id, func(f Faults) time.Duration { return f.UpdateLatency }); err != nil {
+		return err
+	}
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	delete(l.store.candidates[l.name], l.id)
+	return nil
+}
+
+func (l *Lock) Candidates(ctx context.Context) ([]le.Candidate, error) {
+	if err := l.store.inject(ctx, l.id, func(f Faults) time.Duration { return f.GetLatency }); err != nil {
+		return nil, err
+	}
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	var cs []le.Candidate
+	for _, c := range l.store.candidates[l.name] {
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func (l *Lock) Identity() string {
+	return l.id
+}
+
+func (l *Lock) Describe() string {
+	return fmt.Sprintf("memory/%s", l.name)
+}
+
+func (l *Lock) set(ctx context.Context, ler le.Record, version le.Version, create bool) (le.Version, error) {
+	if err := l.store.inject(ctx, l.id, func(f Faults) time.Duration { return f.UpdateLatency }); err != nil {
+		return "", err
+	}
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	if l.store.conflicts > 0 {
+		l.store.conflicts--
+		return "", &le.ConflictError{Lock: l.Describe(), Version: version, Err: errors.New("forced conflict")}
+	}
+	cur, ok := l.store.lookup(l.id, l.name)
+	switch {
+	case create && ok:
+		return "", &le.ConflictError{Lock: l.Describe(), Err: errors.New("record already exists")}
+	case !create && !ok:
+		return "", fmt.Errorf("%s: %w", l.name, os.ErrNotExist)
+	case !create && cur.version != version:
+		return "", &le.ConflictError{Lock: l.Describe(), Version: version}
+	}
+	e, err := l.store.newEntry(ler)
+	if err != nil {
+		return "", err
+	}
+	l.store.put(l.id, l.name, e)
+	return e.version, nil
+}
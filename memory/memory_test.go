@@ -0,0 +1,129 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/leaderelectiontest"
+)
+
+func TestLockConformance(t *testing.T) {
+	s := NewStore()
+	leaderelectiontest.RunLockConformance(t, func(t *testing.T, name, id string) le.Lock {
+		return New(s, name, id)
+	})
+}
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+	a, b := New(s, "test", "a"), New(s, "test", "b")
+	v, err := a.Create(ctx, le.Record{HolderIdentity: "a", LeaseDurationMilliSeconds: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetFaults("a", Faults{ErrorRate: 1})
+	if _, _, _, err := a.Get(ctx); !errors.Is(err, ErrInjected) {
+		t.Fatalf("got error %v, want ErrInjected", err)
+	}
+	if _, _, _, err := b.Get(ctx); err != nil {
+		t.Fatalf("faults of a applied to b: %v", err)
+	}
+
+	s.SetFaults("a", Faults{GetLatency: time.Second})
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, _, _, err := a.Get(tctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+	s.SetFaults("a", Faults{})
+
+	s.ForceConflicts(1)
+	if _, err := a.Update(ctx, le.Record{HolderIdentity: "a"}, v); !errors.Is(err, le.ErrConflict) {
+		t.Fatalf("got error %v, want le.ErrConflict", err)
+	}
+	if v, err = a.Update(ctx, le.Record{HolderIdentity: "a"}, v); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Partition("a")
+	if v, err = a.Update(ctx, le.Record{HolderIdentity: "a", RenewTime: 1}, v); err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := a.Get(ctx); r.RenewTime != 1 {
+		t.Errorf("partitioned identity does not see its own write")
+	}
+	if r, _, _, _ := b.Get(ctx); r.RenewTime == 1 {
+		t.Errorf("write of the partitioned identity is visible to the others")
+	}
+	s.Heal("a")
+	if _, err := a.Update(ctx, le.Record{HolderIdentity: "a"}, v); !errors.Is(err, le.ErrConflict) {
+		t.Errorf("got error %v for the update of a healed identity, want le.ErrConflict", err)
+	}
+
+	if err := s.Takeover("test", "b"); err != nil {
+		t.Fatal(err)
+	}
+	r, ok := s.Record("test")
+	if !ok || r.HolderIdentity != "b" || r.LeaderTransitions != 1 {
+		t.Errorf("got record %+v after takeover", r)
+	}
+}
+
+func TestSharedLock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := NewStore()
+	leading := make(chan string, 2)
+	for _, id := range []string{"a", "b"} {
+		id := id
+		e, err := le.New(le.Config{
+			Lock:          New(s, "shared", id),
+			LeaseDuration: 500 * time.Millisecond,
+			RenewDeadline: 300 * time.Millisecond,
+			RetryPeriod:   50 * time.Millisecond,
+			Callbacks: le.Callbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					leading <- id
+				},
+				OnStoppedLeading: func() {},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go e.Run(ctx)
+	}
+	first := <-leading
+	s.Partition(first)
+	select {
+	case id := <-leading:
+		if id == first {
+			t.Fatalf("%s started leading twice", id)
+		}
+	case <-ctx.Done():
+		t.Fatal("no failover after the partition of the leader")
+	}
+	r, _ := s.Record("shared")
+	if r.LeaderTransitions != 1 {
+		t.Errorf("got %d leader transitions, want 1", r.LeaderTransitions)
+	}
+}
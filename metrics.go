@@ -18,6 +18,7 @@ package leaderelection
 
 import (
 	"sync"
+	"time"
 )
 
 // This file provides abstractions for setting the provider (e.g., prometheus)
@@ -26,6 +27,12 @@ import (
 type leaderMetricsAdapter interface {
 	leaderOn(name string)
 	leaderOff(name string)
+	latency(name, phase, operation string, d time.Duration)
+	transition(name string)
+	renewFailure(name, class string)
+	leaseAge(name string, d time.Duration)
+	sinceRenew(name string, d time.Duration)
+	clockSkew(name string, d time.Duration)
 }
 
 // GaugeMetric represents a single numerical value that can arbitrarily go up
@@ -35,15 +42,51 @@ type SwitchMetric interface {
 	Off(name string)
 }
 
+// LatencyMetric observes the latency of the lock operations ("get", "create"
+// and "update") performed in each phase of the election ("acquire", "renew"
+// and "release").
+type LatencyMetric interface {
+	Observe(name, phase, operation string, d time.Duration)
+}
+
+// CounterMetric represents a value that only goes up.
+type CounterMetric interface {
+	Inc(name string)
+}
+
+// ClassCounterMetric represents values that only go up, one per class.
+type ClassCounterMetric interface {
+	Inc(name, class string)
+}
+
+// DurationMetric represents a duration that can arbitrarily go up and down.
+type DurationMetric interface {
+	Set(name string, d time.Duration)
+}
+
 type noopMetric struct{}
 
-func (noopMetric) On(name string)  {}
-func (noopMetric) Off(name string) {}
+func (noopMetric) On(name string)                                         {}
+func (noopMetric) Off(name string)                                        {}
+func (noopMetric) Observe(name, phase, operation string, d time.Duration) {}
+func (noopMetric) Inc(name string)                                        {}
+func (noopMetric) Set(name string, d time.Duration)                       {}
+
+type noopClassMetric struct{}
+
+func (noopClassMetric) Inc(name, class string) {}
 
 // defaultLeaderMetrics expects the caller to lock before setting any metrics.
 type defaultLeaderMetrics struct {
 	// leader's value indicates if the current process is the owner of name lease
 	leader SwitchMetric
+	// the metrics below are only set by the ExtendedMetricsProviders
+	latencies     LatencyMetric
+	transitions   CounterMetric
+	renewFailures ClassCounterMetric
+	age           DurationMetric
+	renewed       DurationMetric
+	skew          DurationMetric
 }
 
 func (m *defaultLeaderMetrics) leaderOn(name string) {
@@ -60,16 +103,70 @@ func (m *defaultLeaderMetrics) leaderOff(name string) {
 	m.leader.Off(name)
 }
 
+func (m *defaultLeaderMetrics) latency(name, phase, operation string, d time.Duration) {
+	m.latencies.Observe(name, phase, operation, d)
+}
+
+func (m *defaultLeaderMetrics) transition(name string) {
+	m.transitions.Inc(name)
+}
+
+func (m *defaultLeaderMetrics) renewFailure(name, class string) {
+	m.renewFailures.Inc(name, class)
+}
+
+func (m *defaultLeaderMetrics) leaseAge(name string, d time.Duration) {
+	m.age.Set(name, d)
+}
+
+func (m *defaultLeaderMetrics) sinceRenew(name string, d time.Duration) {
+	m.renewed.Set(name, d)
+}
+
+func (m *defaultLeaderMetrics) clockSkew(name string, d time.Duration) {
+	m.skew.Set(name, d)
+}
+
 type noMetrics struct{}
 
-func (noMetrics) leaderOn(name string)  {}
-func (noMetrics) leaderOff(name string) {}
+func (noMetrics) leaderOn(name string)                                   {}
+func (noMetrics) leaderOff(name string)                                  {}
+func (noMetrics) latency(name, phase, operation string, d time.Duration) {}
+func (noMetrics) transition(name string)                                 {}
+func (noMetrics) renewFailure(name, class string)                        {}
+func (noMetrics) leaseAge(name string, d time.Duration)                  {}
+func (noMetrics) sinceRenew(name string, d time.Duration)                {}
+func (noMetrics) clockSkew(name string, d time.Duration)                 {}
 
 // MetricsProvider generates various metrics used by the leader election.
 type MetricsProvider interface {
 	NewLeaderMetric() SwitchMetric
 }
 
+// ExtendedMetricsProvider generates the metrics of the leader election beyond
+// the leader switch. The metrics are given the Config.Name of the electors.
+type ExtendedMetricsProvider interface {
+	MetricsProvider
+	// NewLatencyMetric returns the metric of the lock operations latencies.
+	NewLatencyMetric() LatencyMetric
+	// NewTransitionsMetric returns the counter of the leader changes observed.
+	NewTransitionsMetric() CounterMetric
+	// NewRenewFailuresMetric returns the counter of the failures to renew the
+	// lease, by class of error: "timeout", "conflict", "lost" or "backend".
+	NewRenewFailuresMetric() ClassCounterMetric
+	// NewLeaseAgeMetric returns the metric of the time elapsed since the
+	// current holder acquired the lease, according to the record.
+	NewLeaseAgeMetric() DurationMetric
+	// NewSinceRenewMetric returns the metric of the time elapsed since the
+	// last successful renewal of the lease: by the client while leading, else
+	// the last renewal of the leader it observed.
+	NewSinceRenewMetric() DurationMetric
+	// NewClockSkewMetric returns the metric of the difference between the
+	// time the renewals of the leader were observed and their renew time. It
+	// includes the delay before the renewal was observed, up to RetryPeriod.
+	NewClockSkewMetric() DurationMetric
+}
+
 type noopMetricsProvider struct{}
 
 func (_ noopMetricsProvider) NewLeaderMetric() SwitchMetric {
@@ -81,29 +178,49 @@ var globalMetricsFactory = leaderMetricsFactory{
 }
 
 type leaderMetricsFactory struct {
+	mu              sync.Mutex
 	metricsProvider MetricsProvider
-
-	onlyOnce sync.Once
 }
 
 func (f *leaderMetricsFactory) setProvider(mp MetricsProvider) {
-	f.onlyOnce.Do(func() {
-		f.metricsProvider = mp
-	})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.metricsProvider = mp
 }
 
-func (f *leaderMetricsFactory) newLeaderMetrics() leaderMetricsAdapter {
-	mp := f.metricsProvider
-	if mp == (noopMetricsProvider{}) {
+func (f *leaderMetricsFactory) provider() MetricsProvider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.metricsProvider
+}
+
+func newLeaderMetrics(mp MetricsProvider) leaderMetricsAdapter {
+	if mp == nil || mp == (noopMetricsProvider{}) {
 		return noMetrics{}
 	}
-	return &defaultLeaderMetrics{
-		leader: mp.NewLeaderMetric(),
+	m := &defaultLeaderMetrics{
+		leader:        mp.NewLeaderMetric(),
+		latencies:     noopMetric{},
+		transitions:   noopMetric{},
+		renewFailures: noopClassMetric{},
+		age:           noopMetric{},
+		renewed:       noopMetric{},
+		skew:          noopMetric{},
+	}
+	if mp, ok := mp.(ExtendedMetricsProvider); ok {
+		m.latencies = mp.NewLatencyMetric()
+		m.transitions = mp.NewTransitionsMetric()
+		m.renewFailures = mp.NewRenewFailuresMetric()
+		m.age = mp.NewLeaseAgeMetric()
+		m.renewed = mp.NewSinceRenewMetric()
+		m.skew = mp.NewClockSkewMetric()
 	}
+	return m
 }
 
-// SetProvider sets the metrics provider for all subsequently created work
-// queues. Only the first call has an effect.
+// SetProvider sets the metrics provider of the LeaderElectors subsequently
+// created without Config.MetricsProvider. Each call replaces the previous
+// provider.
 func SetProvider(metricsProvider MetricsProvider) {
 	globalMetricsFactory.setProvider(metricsProvider)
 }
@@ -0,0 +1,227 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/utils/clock"
+)
+
+// ObserverConfig is the configuration of an Observer.
+type ObserverConfig struct {
+	// Lock is the resource that will be observed. The Observer only ever
+	// calls its Get method.
+	Lock Lock
+
+	// RetryPeriod is the duration the Observer waits between two reads
+	// of the record.
+	RetryPeriod time.Duration
+
+	// Clock drives the polling timer and timestamps the observed records.
+	// It defaults to the real clock.
+	Clock clock.Clock
+
+	// Logger is the logger of the Observer. It defaults to the klog logger.
+	Logger logr.Logger
+}
+
+// LeaderChange is sent by the Observer each time the observed leader changes.
+type LeaderChange struct {
+	// Leader is the identity of the new leader. It is empty when the lease
+	// expired, was released or is being transferred.
+	Leader string
+	// Record is the record the change was observed on.
+	Record Record
+}
+
+// Observer watches a Lock without campaigning, e.g. to route requests to the
+// leader. It applies the same expiry logic as the LeaderElector: the leader
+// is the holder of a record that changed less than its lease duration ago.
+type Observer struct {
+	config   ObserverConfig
+	clock    clock.Clock
+	observed observation
+	log      logr.Logger
+	// ran is set by the first Run
+	ran atomic.Bool
+
+	// mu protects the fields below
+	mu sync.Mutex
+	// exists is false while the record does not exist
+	exists bool
+	// reported is the leader last sent on changes
+	reported string
+	changes  chan LeaderChange
+}
+
+// NewObserver creates an Observer from an ObserverConfig.
+func NewObserver(c ObserverConfig) (*Observer, error) {
+	if c.Lock == nil {
+		return nil, fmt.Errorf("Lock must not be nil.")
+	}
+	if c.RetryPeriod < 1 {
+		return nil, fmt.Errorf("retryPeriod must be greater than zero")
+	}
+	if c.Clock == nil {
+		c.Clock = clock.RealClock{}
+	}
+	return &Observer{
+		config:   c,
+		clock:    c.Clock,
+		observed: observation{clock: c.Clock},
+		log:      newLogger(c.Logger, "", c.Lock),
+		changes:  make(chan LeaderChange, 1),
+	}, nil
+}
+
+// Run reads the record every RetryPeriod until ctx is done.
+// The Changes channel is closed when it returns. An Observer runs only once:
+// the later calls return immediately.
+func (o *Observer) Run(ctx context.Context) {
+	if o.ran.Swap(true) {
+		return
+	}
+	defer runtime.HandleCrash()
+	defer close(o.changes)
+	jitterUntil(ctx, o.clock, func() {
+		o.observe(ctx)
+	}, o.config.RetryPeriod, 0)
+}
+
+// Leader returns the identity of the current leader, or the empty string if
+// there is none.
+func (o *Observer) Leader() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.leader()
+}
+
+// Record returns the last observed record, if any.
+func (o *Observer) Record() (Record, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.exists {
+		return Record{}, false
+	}
+	return o.observed.get()
+}
+
+// Changes returns the channel receiving the leader changes. Only the latest
+// change is kept when the receiver lags behind.
+func (o *Observer) Changes() <-chan LeaderChange {
+	return o.changes
+}
+
+func (o *Observer) observe(ctx context.Context) {
+	ler, raw, _, err := o.config.Lock.Get(ctx)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case err == nil:
+		o.exists = true
+		o.observed.observe(ler, raw)
+	case errors.Is(err, os.ErrNotExist):
+		o.exists = false
+	default:
+		// the lease expires if we cannot read it for too long
+		o.log.Error(err, "error retrieving resource lock")
+	}
+	leader := o.leader()
+	if leader == o.reported {
+		return
+	}
+	o.reported = leader
+	c := LeaderChange{Leader: leader}
+	c.Record, _ = o.observed.get()
+	select {
+	case <-o.changes:
+	default:
+	}
+	o.changes <- c
+}
+
+// leader must be called with mu held.
+func (o *Observer) leader() string {
+	ler, ok := o.observed.get()
+	if !o.exists || !ok || ler.TransferTo != "" || !o.observed.held(&ler, o.clock.Now()) {
+		return ""
+	}
+	return ler.HolderIdentity
+}
+
+// observation is the bookkeeping of the last observed record, shared by the
+// LeaderElector and the Observer. Only the local time at which the record
+// changed is trusted, not the timestamps it holds, see the package documentation.
+type observation struct {
+	clock clock.PassiveClock
+
+	// used to lock the fields below
+	lock      sync.Mutex
+	record    Record
+	rawRecord []byte
+	time      time.Time
+}
+
+// set sets a new record and updates the observation time to the current time.
+func (o *observation) set(r *Record) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.record = *r
+	o.time = o.clock.Now()
+}
+
+// observe sets the record read from the lock if its raw representation changed
+// since the last observation, and returns whether it did.
+func (o *observation) observe(r *Record, raw []byte) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if bytes.Equal(o.rawRecord, raw) {
+		return false
+	}
+	o.record = *r
+	o.rawRecord = raw
+	o.time = o.clock.Now()
+	return true
+}
+
+// get returns the observed record, and false if no record was observed yet.
+func (o *observation) get() (Record, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.record, !o.time.IsZero()
+}
+
+// at returns the time of the last observation.
+func (o *observation) at() time.Time {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.time
+}
+
+// held returns whether r, the last observed record, has a holder whose lease
+// has not expired at now.
+func (o *observation) held(r *Record, now time.Time) bool {
+	return len(r.HolderIdentity) > 0 && o.at().Add(time.Millisecond*time.Duration(r.LeaseDurationMilliSeconds)).After(now)
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/memory"
+)
+
+// readOnlyLock fails the test on any write.
+type readOnlyLock struct {
+	le.Lock
+	t *testing.T
+}
+
+func (l readOnlyLock) Create(context.Context, le.Record) (le.Version, error) {
+	l.t.Error("the observer created the record")
+	return "", nil
+}
+
+func (l readOnlyLock) Update(context.Context, le.Record, le.Version) (le.Version, error) {
+	l.t.Error("the observer updated the record")
+	return "", nil
+}
+
+func TestObserver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	o, err := le.NewObserver(le.ObserverConfig{
+		Lock:        readOnlyLock{Lock: memory.New(s, "test", "observer"), t: t},
+		RetryPeriod: retryPeriod,
+		Clock:       clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go o.Run(ctx)
+
+	// waitChange steps the clock until the observer reports a change, and
+	// returns the fake time elapsed.
+	waitChange := func(want string) time.Duration {
+		t.Helper()
+		start := clk.Now()
+		for clk.Since(start) <= 2*leaseDuration {
+			select {
+			case c := <-o.Changes():
+				if c.Leader != want {
+					t.Fatalf("got leader change to %q, want %q", c.Leader, want)
+				}
+				if l := o.Leader(); l != want {
+					t.Errorf("got leader %q, want %q", l, want)
+				}
+				return clk.Since(start)
+			default:
+			}
+			step(clk, retryPeriod/4)
+		}
+		t.Fatalf("no leader change to %q", want)
+		return 0
+	}
+
+	step(clk, retryPeriod)
+	if _, ok := o.Record(); ok || o.Leader() != "" {
+		t.Fatal("observed a missing record")
+	}
+	a := newElector(t, ctx, s, clk, "a")
+	waitFor(t, clk, a.started, retryPeriod)
+	waitChange("a")
+	if r, ok := o.Record(); !ok || r.HolderIdentity != "a" {
+		t.Errorf("got record %+v", r)
+	}
+
+	s.SetFaults("a", memory.Faults{ErrorRate: 1})
+	if expired := waitChange(""); expired < leaseDuration-retryPeriod {
+		t.Errorf("lease observed as expired after %v", expired)
+	}
+	newElector(t, ctx, s, clk, "b")
+	waitChange("b")
+
+	cancel()
+	for range o.Changes() {
+	}
+	// the observer runs only once
+	o.Run(ctx)
+}
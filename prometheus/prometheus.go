@@ -0,0 +1,194 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prometheus implements a leaderelection.ExtendedMetricsProvider
+// exposing the metrics of the LeaderElectors to Prometheus, labelled by
+// elector name:
+//
+//   - leader_election_master_status: 1 while leading, else 0
+//   - leader_election_operation_duration_seconds: the latency of the lock
+//     operations, by phase and operation
+//   - leader_election_transitions_total: the leader changes observed
+//   - leader_election_renew_failures_total: the failures to renew the lease,
+//     by class of error
+//   - leader_election_lease_age_seconds: the time since the lease was acquired
+//   - leader_election_since_last_renew_seconds: the time since the last renewal
+//   - leader_election_clock_skew_seconds: the observed clock skew of the leader
+//
+// The Provider is a prometheus.Collector which is not registered by New, so
+// that tests can create fresh providers:
+//
+//	p := prometheus.New()
+//	registry.MustRegister(p)
+//	le.SetProvider(p)
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	le "go.linka.cloud/leaderelection"
+)
+
+const namespace = "leader_election"
+
+var (
+	_ le.ExtendedMetricsProvider = (*Provider)(nil)
+	_ prometheus.Collector       = (*Provider)(nil)
+)
+
+// Provider is a leaderelection.ExtendedMetricsProvider recording the metrics
+// in Prometheus vectors.
+type Provider struct {
+	leader        *prometheus.GaugeVec
+	latency       *prometheus.HistogramVec
+	transitions   *prometheus.CounterVec
+	renewFailures *prometheus.CounterVec
+	leaseAge      *prometheus.GaugeVec
+	sinceRenew    *prometheus.GaugeVec
+	clockSkew     *prometheus.GaugeVec
+}
+
+// New creates a Provider.
+func New() *Provider {
+	return &Provider{
+		leader: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "master_status",
+			Help:      "Gauge of if the reporting system is master of the relevant lease, 0 indicates backup, 1 indicates master.",
+		}, []string{"name"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the lock operations, by phase (acquire, renew or release) and operation (get, create or update).",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"name", "phase", "operation"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transitions_total",
+			Help:      "Number of leader changes observed.",
+		}, []string{"name"}),
+		renewFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "renew_failures_total",
+			Help:      "Number of failures to renew the lease, by class of error (timeout, conflict, lost or backend).",
+		}, []string{"name", "class"}),
+		leaseAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "lease_age_seconds",
+			Help:      "Time elapsed since the current holder acquired the lease.",
+		}, []string{"name"}),
+		sinceRenew: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "since_last_renew_seconds",
+			Help:      "Time elapsed since the last successful renewal of the lease.",
+		}, []string{"name"}),
+		clockSkew: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "clock_skew_seconds",
+			Help:      "Difference between the time the renewals of the leader were observed and their renew time.",
+		}, []string{"name"}),
+	}
+}
+
+func (p *Provider) collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.leader, p.latency, p.transitions, p.renewFailures, p.leaseAge, p.sinceRenew, p.clockSkew}
+}
+
+// Describe implements prometheus.Collector.
+func (p *Provider) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range p.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (p *Provider) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range p.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (p *Provider) NewLeaderMetric() le.SwitchMetric {
+	return switchMetric{p.leader}
+}
+
+func (p *Provider) NewLatencyMetric() le.LatencyMetric {
+	return latencyMetric{p.latency}
+}
+
+func (p *Provider) NewTransitionsMetric() le.CounterMetric {
+	return counterMetric{p.transitions}
+}
+
+func (p *Provider) NewRenewFailuresMetric() le.ClassCounterMetric {
+	return classCounterMetric{p.renewFailures}
+}
+
+func (p *Provider) NewLeaseAgeMetric() le.DurationMetric {
+	return durationMetric{p.leaseAge}
+}
+
+func (p *Provider) NewSinceRenewMetric() le.DurationMetric {
+	return durationMetric{p.sinceRenew}
+}
+
+func (p *Provider) NewClockSkewMetric() le.DurationMetric {
+	return durationMetric{p.clockSkew}
+}
+
+type switchMetric struct {
+	v *prometheus.GaugeVec
+}
+
+func (s switchMetric) On(name string) {
+	s.v.WithLabelValues(name).Set(1)
+}
+
+func (s switchMetric) Off(name string) {
+	s.v.WithLabelValues(name).Set(0)
+}
+
+type latencyMetric struct {
+	v *prometheus.HistogramVec
+}
+
+func (l latencyMetric) Observe(name, phase, operation string, d time.Duration) {
+	l.v.WithLabelValues(name, phase, operation).Observe(d.Seconds())
+}
+
+type counterMetric struct {
+	v *prometheus.CounterVec
+}
+
+func (c counterMetric) Inc(name string) {
+	c.v.WithLabelValues(name).Inc()
+}
+
+type classCounterMetric struct {
+	v *prometheus.CounterVec
+}
+
+func (c classCounterMetric) Inc(name, class string) {
+	c.v.WithLabelValues(name, class).Inc()
+}
+
+type durationMetric struct {
+	v *prometheus.GaugeVec
+}
+
+func (g durationMetric) Set(name string, d time.Duration) {
+	g.v.WithLabelValues(name).Set(d.Seconds())
+}
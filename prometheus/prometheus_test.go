@@ -0,0 +1,74 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProvider(t *testing.T) {
+	p := New()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(p)
+
+	p.NewLeaderMetric().On("test")
+	p.NewLatencyMetric().Observe("test", "renew", "update", 100*time.Millisecond)
+	p.NewTransitionsMetric().Inc("test")
+	p.NewRenewFailuresMetric().Inc("test", "timeout")
+	p.NewLeaseAgeMetric().Set("test", 12*time.Second)
+	p.NewSinceRenewMetric().Set("test", time.Second)
+	p.NewClockSkewMetric().Set("test", -time.Second)
+
+	want := `
+# HELP leader_election_clock_skew_seconds Difference between the time the renewals of the leader were observed and their renew time.
+# TYPE leader_election_clock_skew_seconds gauge
+leader_election_clock_skew_seconds{name="test"} -1
+# HELP leader_election_lease_age_seconds Time elapsed since the current holder acquired the lease.
+# TYPE leader_election_lease_age_seconds gauge
+leader_election_lease_age_seconds{name="test"} 12
+# HELP leader_election_master_status Gauge of if the reporting system is master of the relevant lease, 0 indicates backup, 1 indicates master.
+# TYPE leader_election_master_status gauge
+leader_election_master_status{name="test"} 1
+# HELP leader_election_renew_failures_total Number of failures to renew the lease, by class of error (timeout, conflict, lost or backend).
+# TYPE leader_election_renew_failures_total counter
+leader_election_renew_failures_total{class="timeout",name="test"} 1
+# HELP leader_election_since_last_renew_seconds Time elapsed since the last successful renewal of the lease.
+# TYPE leader_election_since_last_renew_seconds gauge
+leader_election_since_last_renew_seconds{name="test"} 1
+# HELP leader_election_transitions_total Number of leader changes observed.
+# TYPE leader_election_transitions_total counter
+leader_election_transitions_total{name="test"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"leader_election_clock_skew_seconds",
+		"leader_election_lease_age_seconds",
+		"leader_election_master_status",
+		"leader_election_renew_failures_total",
+		"leader_election_since_last_renew_seconds",
+		"leader_election_transitions_total",
+	); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(p.latency, "leader_election_operation_duration_seconds"); n != 1 {
+		t.Errorf("got %d latency series, want 1", n)
+	}
+	// a fresh provider can be registered in a fresh registry
+	prometheus.NewRegistry().MustRegister(New())
+}
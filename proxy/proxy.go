@@ -0,0 +1,175 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package proxy implements an HTTP middleware sending the requests received
+// by the followers to the leader, at the address it advertises in the record
+// (see le.Config.Address):
+//
+//	h := proxy.New(proxy.Config{Leader: proxy.Elector(e)}, api)
+//	http.ListenAndServe(":8080", h)
+//
+// The requests are served by the wrapped handler on the leader, and reverse
+// proxied, or redirected if Config.Redirect is set, to the leader on the
+// followers. They fail with a 503 Service Unavailable status while no leader,
+// or no leader address, is known. The address is a URL, or a host and port
+// for plain HTTP.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	le "go.linka.cloud/leaderelection"
+)
+
+// ForwardedHeader is set on the requests proxied to the leader. A follower
+// receiving a proxied request, e.g. during a leader change, fails it instead
+// of proxying it again.
+const ForwardedHeader = "X-Leaderelection-Forwarded"
+
+// LeaderFunc returns the address of the current leader, empty if unknown,
+// and whether the process is the leader.
+type LeaderFunc func() (address string, leading bool)
+
+// Elector returns the LeaderFunc of the LeaderElector e. The address is
+// unknown while the lease is being transferred, until the successor acquired
+// it, and while the record still names e after its term ended.
+func Elector(e *le.LeaderElector) LeaderFunc {
+	return func() (string, bool) {
+		s := e.Status()
+		if s.Leading {
+			return s.Record.HolderAddress, true
+		}
+		if s.ExpiresIn <= 0 || s.Record.TransferTo != "" || s.Leader == s.Identity {
+			return "", false
+		}
+		return s.Record.HolderAddress, false
+	}
+}
+
+// Observer returns the LeaderFunc of the Observer o, for the processes which
+// never lead.
+func Observer(o *le.Observer) LeaderFunc {
+	return func() (string, bool) {
+		if o.Leader() == "" {
+			return "", false
+		}
+		r, _ := o.Record()
+		return r.HolderAddress, false
+	}
+}
+
+// Config configures the middleware.
+type Config struct {
+	// Leader returns the current leader. It is required.
+	Leader LeaderFunc
+	// Redirect makes the followers answer with a 307 Temporary Redirect to
+	// the leader instead of proxying the requests.
+	Redirect bool
+	// Transport is the transport of the reverse proxy. It defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// Handler is the middleware.
+type Handler struct {
+	config Config
+	next   http.Handler
+
+	mu      sync.Mutex
+	address string
+	target  *url.URL
+	proxy   *httputil.ReverseProxy
+}
+
+// New returns the middleware serving the requests with next on the leader.
+func New(c Config, next http.Handler) *Handler {
+	return &Handler{config: c, next: next}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	address, leading := h.config.Leader()
+	if leading {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if r.Header.Get(ForwardedHeader) != "" {
+		unavailable(w, errors.New("not the leader"))
+		return
+	}
+	if address == "" {
+		unavailable(w, errors.New("no leader address known"))
+		return
+	}
+	target, p, err := h.leader(address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if h.config.Redirect {
+		u := *r.URL
+		u.Scheme, u.Host = target.Scheme, target.Host
+		u.Path = singleJoiningSlash(target.Path, r.URL.Path)
+		http.Redirect(w, r, u.String(), http.StatusTemporaryRedirect)
+		return
+	}
+	r.Header.Set(ForwardedHeader, "true")
+	p.ServeHTTP(w, r)
+}
+
+// leader returns the URL of the leader at address and its reverse proxy,
+// which are kept until the address changes.
+func (h *Handler) leader(address string) (*url.URL, *httputil.ReverseProxy, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if address == h.address {
+		return h.target, h.proxy, nil
+	}
+	raw := address
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, nil, fmt.Errorf("invalid leader address %q", address)
+	}
+	p := httputil.NewSingleHostReverseProxy(u)
+	p.Transport = h.config.Transport
+	h.address, h.target, h.proxy = address, u, p
+	return u, p, nil
+}
+
+func unavailable(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+// singleJoiningSlash joins a and b with a single slash, as
+// httputil.NewSingleHostReverseProxy does.
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
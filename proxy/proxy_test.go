@@ -0,0 +1,182 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/memory"
+)
+
+func get(t *testing.T, h http.Handler, path string, header http.Header) *http.Response {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func body(t *testing.T, res *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestHandler(t *testing.T) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "leader "+r.URL.Path+" "+r.Header.Get(ForwardedHeader))
+	}))
+	defer leader.Close()
+	local := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "local "+r.URL.Path)
+	})
+	var (
+		address string
+		leading bool
+	)
+	c := Config{Leader: func() (string, bool) {
+		return address, leading
+	}}
+
+	address, leading = leader.URL, true
+	if res := get(t, New(c, local), "/api", nil); body(t, res) != "local /api" {
+		t.Errorf("leader did not serve the request locally")
+	}
+
+	leading = false
+	if res := get(t, New(c, local), "/api", nil); res.StatusCode != http.StatusOK || body(t, res) != "leader /api true" {
+		t.Errorf("follower did not proxy the request")
+	}
+	// host and port addresses are plain HTTP
+	address = strings.TrimPrefix(leader.URL, "http://")
+	if res := get(t, New(c, local), "/api", nil); body(t, res) != "leader /api true" {
+		t.Errorf("follower did not proxy the request to a host and port address")
+	}
+
+	c.Redirect = true
+	res := get(t, New(c, local), "/api?q=1", nil)
+	if loc := res.Header.Get("Location"); res.StatusCode != http.StatusTemporaryRedirect || loc != leader.URL+"/api?q=1" {
+		t.Errorf("got redirect %d to %q", res.StatusCode, loc)
+	}
+
+	for name, tt := range map[string]struct {
+		address string
+		header  http.Header
+		code    int
+	}{
+		"no leader":       {"", nil, http.StatusServiceUnavailable},
+		"forwarded":       {leader.URL, http.Header{ForwardedHeader: {"true"}}, http.StatusServiceUnavailable},
+		"invalid address": {"http://%zz", nil, http.StatusBadGateway},
+	} {
+		address = tt.address
+		if res := get(t, New(c, local), "/api", tt.header); res.StatusCode != tt.code {
+			t.Errorf("%s: got status %d, want %d", name, res.StatusCode, tt.code)
+		}
+	}
+}
+
+func TestElector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := clocktesting.NewFakeClock(time.Now())
+	run := func(id string, started *atomic.Bool) *le.LeaderElector {
+		e, err := le.New(le.Config{
+			Lock:          memory.New(s, "test", id),
+			LeaseDuration: 15 * time.Second,
+			RenewDeadline: 10 * time.Second,
+			RetryPeriod:   2 * time.Second,
+			Clock:         clk,
+			Address:       id + ":8080",
+			Metadata:      map[string]string{"id": id},
+			Callbacks: le.Callbacks{
+				OnStartedLeading: func(context.Context) {
+					started.Store(true)
+				},
+				OnStoppedLeading: func() {},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go e.Run(ctx)
+		return e
+	}
+	var started atomic.Bool
+	a := run("a", &started)
+	for i := 0; !started.Load(); i++ {
+		if i == 10 {
+			t.Fatal("a did not start leading")
+		}
+		clk.Step(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+	b := run("b", new(atomic.Bool))
+	for i := 0; b.GetLeader() == ""; i++ {
+		if i == 10 {
+			t.Fatal("b did not observe the leader")
+		}
+		clk.Step(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+
+	if address, leading := Elector(a)(); address != "a:8080" || !leading {
+		t.Errorf("got leader %q, %v on a", address, leading)
+	}
+	if address, leading := Elector(b)(); address != "a:8080" || leading {
+		t.Errorf("got leader %q, %v on b", address, leading)
+	}
+	if r, _ := s.Record("test"); r.HolderMetadata["id"] != "a" {
+		t.Errorf("got holder metadata %v", r.HolderMetadata)
+	}
+
+	// a neither serves nor forwards the requests to itself once it transferred
+	local := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "local")
+	})
+	h := New(Config{Leader: Elector(a)}, local)
+	if err := a.Transfer(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if res := get(t, h, "/api", nil); res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d during the transfer, want 503", res.StatusCode)
+	}
+	for i := 0; !b.Status().Leading; i++ {
+		if i == 10 {
+			t.Fatal("b did not start leading")
+		}
+		clk.Step(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+	if address, leading := Elector(b)(); address != "b:8080" || !leading {
+		t.Errorf("got leader %q, %v on b after the transfer", address, leading)
+	}
+}
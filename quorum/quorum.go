@@ -0,0 +1,406 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quorum implements a lock backend writing the record to several
+// independent locks, in the style of Redlock: the operations succeed only when
+// a majority of the members succeeded, so that the lock stays available as long
+// as a majority of its members are.
+//
+// The members are compared-and-swapped independently: the version of the
+// composite record holds the versions of each member. When the members diverge,
+// e.g. after a failed or partial write, Get returns the record stored by a
+// majority of the members, and the next Update writes it back to all the
+// members. Without such a record, Get fails with ErrNoMajority until the leases
+// of the diverging records expired, as no holder may be trusted until then, and
+// then returns the record of the most recent term.
+package quorum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/utils/clock"
+
+	le "go.linka.cloud/leaderelection"
+)
+
+var (
+	_ le.Lock         = (*Lock)(nil)
+	_ le.LoggerSetter = (*Lock)(nil)
+)
+
+// ErrNoMajority is returned by Get while no record is stored by a majority of
+// the members, e.g. after a partial write of a challenger.
+var ErrNoMajority = errors.New("quorum: no record stored by a majority of the members")
+
+// Config configures a quorum Lock.
+type Config struct {
+	// Members are the locks the record is written to. They must share the
+	// same identity.
+	Members []le.Lock
+	// Timeout, if set, bounds the duration of each member operation, so that
+	// a hung member does not delay the others.
+	Timeout time.Duration
+	// Clock measures the time the members diverged for. It defaults to the
+	// real clock.
+	Clock clock.PassiveClock
+}
+
+// MemberStatus is the status of a member of the quorum.
+type MemberStatus struct {
+	// Lock is the description of the member lock.
+	Lock string
+	// Err is the error of the last operation of the member, nil if it succeeded.
+	// Conflicts and missing records are not considered as failures.
+	Err error
+	// LastSuccess is the time of the last successful operation of the member.
+	LastSuccess time.Time
+}
+
+// Lock is a le.Lock on a majority of its members.
+type Lock struct {
+	members []le.Lock
+	timeout time.Duration
+	clock   clock.PassiveClock
+
+	mu     sync.Mutex
+	status []MemberStatus
+	// split is the version of the members last found without a majority
+	// record, and splitTime the time it was first found.
+	split     le.Version
+	splitTime time.Time
+}
+
+// New returns a Lock on the members of the configuration.
+func New(c Config) (*Lock, error) {
+	if len(c.Members) == 0 {
+		return nil, errors.New("quorum: members must not be empty")
+	}
+	if c.Clock == nil {
+		c.Clock = clock.RealClock{}
+	}
+	l := &Lock{members: c.Members, timeout: c.Timeout, clock: c.Clock}
+	for _, m := range c.Members {
+		if m.Identity() != c.Members[0].Identity() {
+			return nil, fmt.Errorf("quorum: %s identity %q differs from %q", m.Describe(), m.Identity(), c.Members[0].Identity())
+		}
+		l.status = append(l.status, MemberStatus{Lock: m.Describe()})
+	}
+	return l, nil
+}
+
+// Status returns the status of the members, in the configuration order.
+func (l *Lock) Status() []MemberStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]MemberStatus(nil), l.status...)
+}
+
+// Failing returns the description of the members whose last operation failed.
+func (l *Lock) Failing() []string {
+	var out []string
+	for _, s := range l.Status() {
+		if s.Err != nil {
+			out = append(out, s.Lock)
+		}
+	}
+	return out
+}
+
+// result is the outcome of a member operation.
+type result struct {
+	record  *le.Record
+	raw     []byte
+	version le.Version
+	err     error
+}
+
+func (l *Lock) Get(ctx context.Context) (*le.Record, []byte, le.Version, error) {
+	rs := l.each(ctx, indexes(0, len(l.members)), func(ctx context.Context, i int, m le.Lock) result {
+		r, raw, v, err := m.Get(ctx)
+		return result{record: r, raw: raw, version: v, err: err}
+	})
+	var (
+		found, missing int
+		errs           []error
+	)
+	for i, r := range rs {
+		switch {
+		case r.err == nil:
+			found++
+		case errors.Is(r.err, os.ErrNotExist):
+			missing++
+		default:
+			errs = append(errs, fmt.Errorf("%s: %w", l.members[i].Describe(), r.err))
+		}
+	}
+	if found+missing < l.quorum() {
+		return nil, nil, "", l.error(found+missing, errs)
+	}
+	if found == 0 {
+		return nil, nil, "", fmt.Errorf("%s: %w", l.Describe(), os.ErrNotExist)
+	}
+	versions := make([]le.Version, len(rs))
+	for i, r := range rs {
+		versions[i] = r.version
+	}
+	version := encodeVersion(versions)
+	ler, err := l.reconcile(rs, version)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	// the raw record is marshalled again, as each backend has its own
+	// representation, so that it only changes with the record
+	raw, err := json.Marshal(ler)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return ler, raw, version, nil
+}
+
+func (l *Lock) Create(ctx context.Context, ler le.Record) (le.Version, error) {
+	return l.write(ctx, ler, make([]le.Version, len(l.members)))
+}
+
+func (l *Lock) Update(ctx context.Context, ler le.Record, version le.Version) (le.Version, error) {
+	versions, err := decodeVersion(version, len(l.members))
+	if err != nil {
+		return "", &le.ConflictError{Lock: l.Describe(), Version: version, Err: err}
+	}
+	return l.write(ctx, ler, versions)
+}
+
+// write updates the members, creating the record on the members whose
+// version is empty.
+//
+// The first reachable member arbitrates between concurrent writers: the members
+// are written in order until one succeeds, and the remaining ones concurrently.
+// Otherwise each member could be won by a different writer, none of them
+// reaching a majority.
+func (l *Lock) write(ctx context.Context, ler le.Record, versions []le.Version) (le.Version, error) {
+	f := func(ctx context.Context, i int, m le.Lock) result {
+		var (
+			v   le.Version
+			err error
+		)
+		if versions[i] == "" {
+			v, err = m.Create(ctx, ler)
+		} else {
+			v, err = m.Update(ctx, ler, versions[i])
+		}
+		return result{version: v, err: err}
+	}
+	rs := make([]result, len(l.members))
+	for i := range l.members {
+		rs[i] = l.each(ctx, []int{i}, f)[i]
+		if errors.Is(rs[i].err, le.ErrConflict) {
+			break
+		}
+		if rs[i].err == nil {
+			copy(rs[i+1:], l.each(ctx, indexes(i+1, len(l.members)), f)[i+1:])
+			break
+		}
+	}
+	var (
+		ok, conflicts int
+		errs          []error
+	)
+	out := make([]le.Version, len(rs))
+	for i, r := range rs {
+		switch {
+		case r.err == nil && r.version != "":
+			ok++
+			out[i] = r.version
+		case r.err == nil:
+			// not written after a conflict on the arbiter
+		case errors.Is(r.err, le.ErrConflict):
+			conflicts++
+			errs = append(errs, r.err)
+		default:
+			errs = append(errs, fmt.Errorf("%s: %w", l.members[i].Describe(), r.err))
+		}
+	}
+	if ok >= l.quorum() {
+		return encodeVersion(out), nil
+	}
+	if conflicts > 0 {
+		return "", &le.ConflictError{Lock: l.Describe(), Version: encodeVersion(versions), Err: errors.Join(errs...)}
+	}
+	return "", l.error(ok, errs)
+}
+
+// indexes returns the integers in [from, to).
+func indexes(from, to int) []int {
+	var out []int
+	for i := from; i < to; i++ {
+		out = append(out, i)
+	}
+	return out
+}
+
+// Consistency returns le.CompareAndSwap if all the members do: two writes of
+// the same version cannot both succeed on a majority of the members.
+func (l *Lock) Consistency() le.Consistency {
+	for _, m := range l.members {
+		if m.Consistency() != le.CompareAndSwap {
+			return le.BestEffort
+		}
+	}
+	return le.CompareAndSwap
+}
+
+// SetLogger sets the logger of the members implementing le.LoggerSetter, with
+// their description as the member value.
+func (l *Lock) SetLogger(log logr.Logger) {
+	for _, m := range l.members {
+		if s, ok := m.(le.LoggerSetter); ok {
+			s.SetLogger(log.WithValues("member", m.Describe()))
+		}
+	}
+}
+
+// RecordEvent records the event on all the members.
+func (l *Lock) RecordEvent(s string) {
+	for _, m := range l.members {
+		m.RecordEvent(s)
+	}
+}
+
+func (l *Lock) Identity() string {
+	return l.members[0].Identity()
+}
+
+func (l *Lock) Describe() string {
+	var ds []string
+	for _, m := range l.members {
+		ds = append(ds, m.Describe())
+	}
+	return fmt.Sprintf("quorum[%s]", strings.Join(ds, ","))
+}
+
+// quorum returns the number of members forming a majority.
+func (l *Lock) quorum() int {
+	return len(l.members)/2 + 1
+}
+
+func (l *Lock) error(ok int, errs []error) error {
+	return fmt.Errorf("%s: %d/%d members succeeded, %d needed: %w", l.Describe(), ok, len(l.members), l.quorum(), errors.Join(errs...))
+}
+
+// each runs f on the members at the given indexes concurrently, and updates
+// their status. The results of the other members are left empty.
+func (l *Lock) each(ctx context.Context, idx []int, f func(ctx context.Context, i int, m le.Lock) result) []result {
+	rs := make([]result, len(l.members))
+	var wg sync.WaitGroup
+	for _, i := range idx {
+		i, m := i, l.members[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := ctx
+			if l.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, l.timeout)
+				defer cancel()
+			}
+			rs[i] = f(ctx, i, m)
+		}()
+	}
+	wg.Wait()
+	now := l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, i := range idx {
+		r := rs[i]
+		if r.err != nil && !errors.Is(r.err, os.ErrNotExist) && !errors.Is(r.err, le.ErrConflict) {
+			l.status[i].Err = r.err
+			continue
+		}
+		l.status[i].Err = nil
+		l.status[i].LastSuccess = now
+	}
+	return rs
+}
+
+// reconcile returns the record stored by a majority of the members, found at
+// version. Otherwise it returns ErrNoMajority, until the members stayed at
+// version for the longest lease duration of their records, and then the
+// record of the most recent term.
+// The records are compared by value, as the members may have different raw
+// representations.
+func (l *Lock) reconcile(rs []result, version le.Version) (*le.Record, error) {
+	var (
+		best  *le.Record
+		lease time.Duration
+	)
+	counts := make(map[string]int)
+	for _, r := range rs {
+		if r.err != nil {
+			continue
+		}
+		k, _ := json.Marshal(r.record)
+		if counts[string(k)]++; counts[string(k)] >= l.quorum() {
+			return r.record, nil
+		}
+		if best == nil || newer(r.record, best) {
+			best = r.record
+		}
+		if d := time.Duration(r.record.LeaseDurationMilliSeconds) * time.Millisecond; d > lease {
+			lease = d
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if version != l.split {
+		l.split, l.splitTime = version, l.clock.Now()
+	}
+	if l.clock.Since(l.splitTime) < lease {
+		return nil, fmt.Errorf("%s: %w", l.Describe(), ErrNoMajority)
+	}
+	return best, nil
+}
+
+// newer returns whether a is more recent than b.
+func newer(a, b *le.Record) bool {
+	if a.LeaderTransitions != b.LeaderTransitions {
+		return a.LeaderTransitions > b.LeaderTransitions
+	}
+	if a.RenewTime != b.RenewTime {
+		return a.RenewTime > b.RenewTime
+	}
+	return a.HolderIdentity < b.HolderIdentity
+}
+
+func encodeVersion(versions []le.Version) le.Version {
+	b, _ := json.Marshal(versions)
+	return le.Version(b)
+}
+
+func decodeVersion(version le.Version, n int) ([]le.Version, error) {
+	var versions []le.Version
+	if err := json.Unmarshal([]byte(version), &versions); err != nil {
+		return nil, fmt.Errorf("invalid version %q: %w", version, err)
+	}
+	if len(versions) != n {
+		return nil, fmt.Errorf("invalid version %q: %d members, want %d", version, len(versions), n)
+	}
+	return versions, nil
+}
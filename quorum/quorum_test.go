@@ -0,0 +1,173 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quorum
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/leaderelectiontest"
+	"go.linka.cloud/leaderelection/memory"
+)
+
+func newLock(t *testing.T, stores []*memory.Store, name, id string, clk clock.PassiveClock) *Lock {
+	var ms []le.Lock
+	for _, s := range stores {
+		ms = append(ms, memory.New(s, name, id))
+	}
+	l, err := New(Config{Members: ms, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLockConformance(t *testing.T) {
+	stores := []*memory.Store{memory.NewStore(), memory.NewStore(), memory.NewStore()}
+	leaderelectiontest.RunLockConformance(t, func(t *testing.T, name, id string) le.Lock {
+		return newLock(t, stores, name, id, nil)
+	})
+}
+
+func TestMajority(t *testing.T) {
+	ctx := context.Background()
+	stores := []*memory.Store{memory.NewStore(), memory.NewStore(), memory.NewStore()}
+	a := newLock(t, stores, "test", "a", nil)
+	stores[0].SetFaults("a", memory.Faults{ErrorRate: 1})
+	v, err := a.Create(ctx, le.Record{HolderIdentity: "a", LeaseDurationMilliSeconds: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := a.Failing(), []string{"memory/test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got failing members %v, want %v", got, want)
+	}
+
+	stores[0].SetFaults("a", memory.Faults{})
+	stores[1].SetFaults("a", memory.Faults{ErrorRate: 1})
+	stores[2].SetFaults("a", memory.Faults{ErrorRate: 1})
+	if _, _, _, err := a.Get(ctx); !errors.Is(err, memory.ErrInjected) {
+		t.Fatalf("got error %v without a majority, want ErrInjected", err)
+	}
+	if _, err := a.Update(ctx, le.Record{HolderIdentity: "a", RenewTime: 1}, v); err == nil || errors.Is(err, le.ErrConflict) {
+		t.Fatalf("got error %v without a majority", err)
+	}
+	if got := a.Status(); got[0].Err != nil || got[1].Err == nil || got[2].Err == nil {
+		t.Errorf("got status %+v", got)
+	}
+	stores[1].SetFaults("a", memory.Faults{})
+	stores[2].SetFaults("a", memory.Faults{})
+
+	// the member which missed the creation is repaired by the next update
+	ler, _, v, err := a.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ler.RenewTime = 2
+	if _, err := a.Update(ctx, *ler, v); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range stores {
+		if r, ok := s.Record("test"); !ok || r.RenewTime != 2 {
+			t.Errorf("member %d: got record %+v after the update", i, r)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	stores := []*memory.Store{memory.NewStore(), memory.NewStore(), memory.NewStore()}
+	clk := clocktesting.NewFakeClock(time.Now())
+	a := newLock(t, stores, "test", "a", clk)
+	if _, err := a.Create(ctx, le.Record{HolderIdentity: "a", LeaseDurationMilliSeconds: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	// a minority write of a newer term loses against the majority record
+	if err := stores[0].Takeover("test", "b"); err != nil {
+		t.Fatal(err)
+	}
+	ler, _, v, err := a.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ler.HolderIdentity != "a" {
+		t.Errorf("got holder %q, want the majority holder a", ler.HolderIdentity)
+	}
+	if _, err := a.Update(ctx, *ler, v); err != nil {
+		t.Fatal(err)
+	}
+	// without a majority record, the most recent term wins once the leases expired
+	if err := stores[1].Takeover("test", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores[2].Takeover("test", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := a.Get(ctx); !errors.Is(err, ErrNoMajority) {
+		t.Fatalf("got error %v without a majority record, want ErrNoMajority", err)
+	}
+	clk.Step(time.Second)
+	if ler, _, _, err = a.Get(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ler.HolderIdentity != "b" && ler.HolderIdentity != "c" {
+		t.Errorf("got holder %q, want the holder of the most recent term", ler.HolderIdentity)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	ctx := context.Background()
+	var stores []*memory.Store
+	for i := 0; i < 5; i++ {
+		stores = append(stores, memory.NewStore())
+	}
+	clk := clocktesting.NewFakeClock(time.Now())
+	a := newLock(t, stores, "test", "a", clk)
+	if _, err := a.Create(ctx, le.Record{HolderIdentity: "a", LeaseDurationMilliSeconds: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	// the partial writes of b and c leave a with no majority against it: 2/2/1
+	for _, i := range []int{0, 1} {
+		if err := stores[i].Takeover("test", "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stores[2].Takeover("test", "c"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ler, _, _, err := a.Get(ctx); !errors.Is(err, ErrNoMajority) {
+			t.Fatalf("got record %+v, error %v on a split, want ErrNoMajority", ler, err)
+		}
+		clk.Step(500 * time.Millisecond)
+	}
+	ler, _, v, err := a.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and the next update repairs the members
+	if _, err := a.Update(ctx, *ler, v); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _, err := a.Get(ctx); err != nil || got.HolderIdentity != ler.HolderIdentity {
+		t.Errorf("got record %+v, error %v after the repair", got, err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// RecordVersion is the version of the Record schema written by this package:
+//
+//   - 0: the unversioned records
+//   - 1: the versioned records, holding the holder address and metadata
+const RecordVersion = 1
+
+// recordFields are the lower case JSON keys of the Record fields, which are
+// matched case-insensitively when decoding.
+var recordFields = func() map[string]bool {
+	m := make(map[string]bool)
+	t := reflect.TypeOf(Record{})
+	for i := 0; i < t.NumField(); i++ {
+		if tag, ok := t.Field(i).Tag.Lookup("json"); ok {
+			m[strings.ToLower(strings.Split(tag, ",")[0])] = true
+		}
+	}
+	return m
+}()
+
+// record has the fields of Record, without its JSON methods.
+type record Record
+
+// MarshalJSON encodes the record along with the unknown fields it was decoded with.
+func (r Record) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(record(r))
+	if err != nil || len(r.unknown) == 0 {
+		return b, err
+	}
+	m := make(map[string]json.RawMessage, len(r.unknown)+len(recordFields))
+	for k, v := range r.unknown {
+		m[k] = v
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes a record of any version, keeping the fields it does
+// not know, e.g. written by a newer version of the package, so that they are
+// encoded back by MarshalJSON.
+func (r *Record) UnmarshalJSON(b []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	var rec record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return err
+	}
+	for k := range m {
+		if recordFields[strings.ToLower(k)] {
+			delete(m, k)
+		}
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	rec.unknown = m
+	*r = Record(rec)
+	return nil
+}
+
+// upgrade returns r, to be written over old, with the fields of old this
+// version of the package does not know, and the current schema version unless
+// old was written with a newer one, whose fields are thus still there.
+func (r Record) upgrade(old *Record) Record {
+	r.Version = RecordVersion
+	if old != nil {
+		r.unknown = old.unknown
+		if old.Version > r.Version {
+			r.Version = old.Version
+		}
+	}
+	return r
+}
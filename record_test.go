@@ -0,0 +1,143 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/memory"
+)
+
+// newerRecord is a record written by a newer version of the package.
+const newerRecord = `{"version":2,"holderIdentity":"z","leaseDurationMilliSeconds":15000,"acquireTime":1000,"renewTime":2000,"leaderTransitions":3,"holderZone":"eu-west-1a","fencing":{"epoch":7}}`
+
+func TestRecordJSON(t *testing.T) {
+	var old le.Record
+	if err := json.Unmarshal([]byte(`{"holderIdentity":"a","leaseDurationMilliSeconds":15000,"acquireTime":1000,"renewTime":2000,"leaderTransitions":1}`), &old); err != nil {
+		t.Fatal(err)
+	}
+	if old.Version != 0 || old.HolderIdentity != "a" || old.LeaderTransitions != 1 {
+		t.Errorf("got unversioned record %+v", old)
+	}
+
+	var r le.Record
+	if err := json.Unmarshal([]byte(newerRecord), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != 2 || r.HolderIdentity != "z" || r.LeaderTransitions != 3 {
+		t.Errorf("got newer record %+v", r)
+	}
+	r.RenewTime = 3000
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(newerRecord), &want); err != nil {
+		t.Fatal(err)
+	}
+	want["renewTime"] = 3000.
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s, want the newer record with the new renew time", b)
+	}
+}
+
+func TestRecordUpgrade(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	var r le.Record
+	if err := json.Unmarshal([]byte(newerRecord), &r); err != nil {
+		t.Fatal(err)
+	}
+	// the lease of z expired long ago
+	if _, err := memory.New(s, "test", "z").Create(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	a := newElector(t, ctx, s, clk, "a")
+	waitFor(t, clk, a.started, 2*leaseDuration)
+	step(clk, retryPeriod)
+
+	stored, _ := s.Record("test")
+	// the record keeps the version it was written with
+	if stored.Version != 2 || stored.HolderIdentity != "a" || stored.LeaderTransitions != 4 {
+		t.Errorf("got record %+v", stored)
+	}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["holderZone"] != "eu-west-1a" || !reflect.DeepEqual(m["fencing"], map[string]any{"epoch": 7.}) {
+		t.Errorf("the unknown fields were not preserved: %s", b)
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	var r le.Record
+	if err := json.Unmarshal([]byte(newerRecord), &r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memory.New(s, "test", "z").Create(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	// a renews and releases the newer record
+	a := newElector(t, ctx, s, clk, "a", func(c *le.Config) {
+		c.ResignCooldown = 2 * leaseDuration
+	})
+	waitFor(t, clk, a.started, 2*leaseDuration)
+	step(clk, 2*retryPeriod)
+	if err := a.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-a.stopped
+
+	// and the newer version reads it back as it wrote it
+	stored, _ := s.Record("test")
+	b, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(newerRecord), &want); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"version", "holderZone", "fencing"} {
+		if !reflect.DeepEqual(got[k], want[k]) {
+			t.Errorf("got %s %v, want %v", k, got[k], want[k])
+		}
+	}
+	if got["holderIdentity"] != "a" || got["leaseDurationMilliSeconds"] != 1. {
+		t.Errorf("got %s, want the lease of a released", b)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Runnable is a component running while the client is leading.
+type Runnable interface {
+	// Start runs the component until ctx is done. The context carries the
+	// fencing token of the leadership term, see TokenFromContext, and expires
+	// with the lease, see Config.SafetyFraction.
+	Start(ctx context.Context) error
+}
+
+// RunnableFunc is a function implementing Runnable.
+type RunnableFunc func(ctx context.Context) error
+
+func (f RunnableFunc) Start(ctx context.Context) error {
+	return f(ctx)
+}
+
+// RestartPolicy tells whether a Runnable is restarted when Start returns
+// while the client is still leading.
+type RestartPolicy int
+
+const (
+	// RestartNever never restarts the Runnable.
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure restarts the Runnable when Start returns an error.
+	RestartOnFailure
+	// RestartAlways restarts the Runnable whenever Start returns.
+	RestartAlways
+)
+
+func (p RestartPolicy) String() string {
+	switch p {
+	case RestartNever:
+		return "Never"
+	case RestartOnFailure:
+		return "OnFailure"
+	case RestartAlways:
+		return "Always"
+	default:
+		return fmt.Sprintf("RestartPolicy(%d)", int(p))
+	}
+}
+
+// RunnableOptions configures a Runnable added to a LeaderElector.
+type RunnableOptions struct {
+	// Name identifies the Runnable in the logs.
+	Name string
+	// Restart is the restart policy of the Runnable.
+	Restart RestartPolicy
+	// RestartDelay is the delay before restarting the Runnable.
+	// It defaults to RetryPeriod.
+	RestartDelay time.Duration
+	// StopTimeout is the duration the Runnable is given to return once its
+	// context is cancelled, before the previous Runnable is stopped anyway.
+	// It defaults to RenewDeadline.
+	StopTimeout time.Duration
+	// Critical makes the client resign when the Runnable returns and is not
+	// restarted, see LeaderElector.Resign.
+	Critical bool
+}
+
+type runnable struct {
+	Runnable
+	opts RunnableOptions
+}
+
+// running is a Runnable started for the current leadership term.
+type running struct {
+	*runnable
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// AddRunnable registers a Runnable started with each leadership term, after
+// the ones added before. The Runnables are stopped in the reverse order when
+// the term ends, before OnStoppedLeading is called.
+// A Runnable added during a leadership term is only started with the next one.
+func (le *LeaderElector) AddRunnable(r Runnable, o RunnableOptions) {
+	if o.RestartDelay <= 0 {
+		o.RestartDelay = le.config.RetryPeriod
+	}
+	if o.StopTimeout <= 0 {
+		o.StopTimeout = le.config.RenewDeadline
+	}
+	le.runnablesLock.Lock()
+	defer le.runnablesLock.Unlock()
+	le.runnables = append(le.runnables, &runnable{Runnable: r, opts: o})
+}
+
+// startRunnables starts the registered Runnables with contexts derived from
+// parent, each one once Start was called on the previous one. The parent is not
+// cancelled with the term, so that they are cancelled one after the other by
+// stopRunnables.
+func (le *LeaderElector) startRunnables(parent context.Context) {
+	le.runnablesLock.Lock()
+	defer le.runnablesLock.Unlock()
+	for _, r := range le.runnables {
+		ctx, cancel := context.WithCancel(parent)
+		rr := &running{runnable: r, cancel: cancel, done: make(chan struct{})}
+		le.running = append(le.running, rr)
+		started := make(chan struct{})
+		go func() {
+			defer close(rr.done)
+			le.supervise(ctx, rr, started)
+		}()
+		<-started
+	}
+}
+
+// supervise runs the Runnable until ctx is done, restarting it according to
+// its policy, and resigns if it is critical and was not restarted. started is
+// closed when Start is first called.
+func (le *LeaderElector) supervise(ctx context.Context, r *running, started chan struct{}) {
+	for {
+		if started != nil {
+			close(started)
+			started = nil
+		}
+		err := r.Start(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			le.log.Error(err, "runnable failed", "runnable", r.opts.Name)
+		} else {
+			le.log.Info("runnable returned", "runnable", r.opts.Name)
+		}
+		if r.opts.Restart == RestartNever || (r.opts.Restart == RestartOnFailure && err == nil) {
+			break
+		}
+		if !sleep(ctx, le.clock, r.opts.RestartDelay) {
+			return
+		}
+		le.log.Info("restarting runnable", "runnable", r.opts.Name)
+	}
+	if !r.opts.Critical {
+		return
+	}
+	le.log.Info("critical runnable returned, resigning lease", "runnable", r.opts.Name)
+	// Resign waits for the runnables to be stopped, this one included
+	go func() {
+		if err := le.Resign(context.Background()); err != nil && !errors.Is(err, ErrNotLeader) {
+			le.log.Error(err, "failed to resign after the critical runnable returned", "runnable", r.opts.Name)
+		}
+	}()
+}
+
+// stopRunnables stops the Runnables of the term in the reverse order of their
+// start, giving each one its StopTimeout to return.
+func (le *LeaderElector) stopRunnables() {
+	le.runnablesLock.Lock()
+	running := le.running
+	le.running = nil
+	le.runnablesLock.Unlock()
+	for i := len(running) - 1; i >= 0; i-- {
+		r := running[i]
+		r.cancel()
+		select {
+		case <-r.done:
+		case <-le.clock.After(r.opts.StopTimeout):
+			le.log.Error(nil, "runnable did not stop", "runnable", r.opts.Name, "timeout", r.opts.StopTimeout)
+		}
+	}
+}
@@ -21,28 +21,42 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"sync"
 
+	"github.com/go-logr/logr"
 	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
 
 	le "go.linka.cloud/leaderelection"
 )
 
-var _ le.Lock = (*lock)(nil)
+var (
+	_ le.Lock              = (*lock)(nil)
+	_ le.CandidateRegistry = (*lock)(nil)
+	_ le.LoggerSetter      = (*lock)(nil)
+)
 
-func New(_ context.Context, endpoint, bucket, prefix, name, id string, opts *minio.Options) (le.Lock, error) {
+// New returns a lock on the object prefix/name.lock.json of the bucket. The
+// lock logs with the logger of ctx (see klog.NewContext), which defaults to
+// the klog logger, until SetLogger replaces it.
+func New(ctx context.Context, endpoint, bucket, prefix, name, id string, opts *minio.Options) (le.Lock, error) {
 	c, err := minio.New(endpoint, opts)
 	if err != nil {
 		return nil, err
 	}
 	key := fmt.Sprintf("%s/%s.lock.json", prefix, name)
 	return &lock{
-		c:      c,
-		id:     id,
-		name:   name,
-		bucket: bucket,
-		key:    key,
+		c:          c,
+		id:         id,
+		name:       name,
+		bucket:     bucket,
+		key:        key,
+		candidates: fmt.Sprintf("%s/%s.candidates/", prefix, name),
+		log:        klog.FromContext(ctx).WithValues("lock", key, "identity", id),
 	}, nil
 }
 
@@ -55,43 +69,63 @@ type lock struct {
 
 	bucket string
 	key    string
+	// candidates is the prefix of the candidate objects
+	candidates string
 
-	etag string
+	log logr.Logger
 }
 
-func (l *lock) Get(ctx context.Context) (*le.Record, []byte, error) {
+func (l *lock) Get(ctx context.Context) (*le.Record, []byte, le.Version, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	s, err := l.c.StatObject(ctx, l.bucket, l.key, minio.StatObjectOptions{})
+	s, err := l.stat(ctx)
 	if isNotFound(err) {
-		return nil, nil, fmt.Errorf("%s: %w", l.key, os.ErrNotExist)
+		return nil, nil, "", fmt.Errorf("%s: %w", l.key, os.ErrNotExist)
 	}
-	l.etag = s.ETag
-
-	o, err := l.c.GetObject(ctx, l.bucket, l.key, minio.GetObjectOptions{})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
-	defer o.Close()
 
-	b, err := io.ReadAll(o)
+	// only read the version we just stat'ed, so that the record always matches the etag
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetMatchETag(s.ETag); err != nil {
+		return nil, nil, "", err
+	}
+	b, err := l.read(ctx, opts)
+	if err != nil {
+		return nil, nil, "", err
+	}
 	var ler le.Record
 	if err := json.Unmarshal(b, &ler); err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
-	return &ler, b, nil
+	return &ler, b, le.Version(s.ETag), nil
 }
 
-func (l *lock) Create(ctx context.Context, ler le.Record) error {
-	return l.set(ctx, ler)
+func (l *lock) Create(ctx context.Context, ler le.Record) (le.Version, error) {
+	return l.set(ctx, ler, "")
 }
 
-func (l *lock) Update(ctx context.Context, ler le.Record) error {
-	return l.set(ctx, ler)
+func (l *lock) Update(ctx context.Context, ler le.Record, version le.Version) (le.Version, error) {
+	return l.set(ctx, ler, version)
 }
 
-func (l *lock) RecordEvent(_ string) {}
+// Consistency returns le.BestEffort: updates are conditional on the object's
+// ETag, which is enforced by the servers supporting conditional writes,
+// but the creation of the record is not atomic.
+func (l *lock) Consistency() le.Consistency {
+	return le.BestEffort
+}
+
+func (l *lock) RecordEvent(m string) {
+	l.log.Info("lock event", "event", m)
+}
+
+// SetLogger replaces the logger of the lock, see le.LoggerSetter.
+func (l *lock) SetLogger(log logr.Logger) {
+	l.log = log
+}
 
 func (l *lock) Identity() string {
 	return l.id
@@ -101,38 +135,142 @@ func (l *lock) Describe() string {
 	return fmt.Sprintf("s3/%s", l.name)
 }
 
-func (l *lock) set(ctx context.Context, ler le.Record) error {
+// Register writes the candidate object of the lock identity.
+func (l *lock) Register(ctx context.Context, c le.Candidate) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = l.c.PutObject(ctx, l.bucket, l.candidateKey(), bytes.NewReader(b), int64(len(b)), minio.PutObjectOptions{ContentType: "application/json"})
+	return err
+}
+
+// Unregister removes the candidate object of the lock identity.
+func (l *lock) Unregister(ctx context.Context) error {
+	return l.c.RemoveObject(ctx, l.bucket, l.candidateKey(), minio.RemoveObjectOptions{})
+}
+
+// Candidates reads the candidate objects of the election.
+func (l *lock) Candidates(ctx context.Context) ([]le.Candidate, error) {
+	// stop the listing on early returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var cs []le.Candidate
+	for i := range l.c.ListObjects(ctx, l.bucket, minio.ListObjectsOptions{Prefix: l.candidates}) {
+		if i.Err != nil {
+			return nil, i.Err
+		}
+		o, err := l.c.GetObject(ctx, l.bucket, i.Key, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(o)
+		o.Close()
+		// the candidate unregistered since the listing
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c le.Candidate
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("%s: %w", i.Key, err)
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func (l *lock) candidateKey() string {
+	return l.candidates + url.PathEscape(l.id) + ".json"
+}
+
+func (l *lock) set(ctx context.Context, ler le.Record, version le.Version) (le.Version, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	b, err := json.Marshal(ler)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	s, err := l.c.StatObject(ctx, l.bucket, l.key, minio.StatObjectOptions{})
+	s, err := l.stat(ctx)
 	if err != nil && !isNotFound(err) {
-		return err
+		return "", err
 	}
-	if s.ETag != l.etag {
-		return fmt.Errorf("etag mismatch: %s != %s", s.ETag, l.etag)
+	if le.Version(s.ETag) != version {
+		return "", &le.ConflictError{Lock: l.Describe(), Version: version, Err: fmt.Errorf("etag mismatch: %s != %s", s.ETag, version)}
 	}
 
-	o, err := l.c.PutObject(ctx, l.bucket, l.key, bytes.NewReader(b), int64(len(b)), minio.PutObjectOptions{ContentType: "application/json"})
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	// let the server reject the write if another candidate updated the record
+	// since we stat'ed it, so that two candidates cannot start the same term
+	if version != "" {
+		opts.SetMatchETag(string(version))
+	}
+	ctx, span := le.StartSpan(ctx, "s3.PutObject", l.attributes()...)
+	o, err := l.c.PutObject(ctx, l.bucket, l.key, bytes.NewReader(b), int64(len(b)), opts)
+	if isPreconditionFailed(err) {
+		err = &le.ConflictError{Lock: l.Describe(), Version: version, Err: err}
+	}
+	le.EndSpan(span, err)
 	if err != nil {
-		return err
+		return "", err
+	}
+	return le.Version(o.ETag), nil
+}
+
+// stat stats the record object.
+func (l *lock) stat(ctx context.Context) (s minio.ObjectInfo, err error) {
+	ctx, span := le.StartSpan(ctx, "s3.StatObject", l.attributes()...)
+	defer func() {
+		if isNotFound(err) {
+			le.EndSpan(span, os.ErrNotExist)
+		} else {
+			le.EndSpan(span, err)
+		}
+	}()
+	return l.c.StatObject(ctx, l.bucket, l.key, minio.StatObjectOptions{})
+}
+
+// read reads the record object.
+func (l *lock) read(ctx context.Context, opts minio.GetObjectOptions) (b []byte, err error) {
+	ctx, span := le.StartSpan(ctx, "s3.GetObject", l.attributes()...)
+	defer func() {
+		le.EndSpan(span, err)
+	}()
+	o, err := l.c.GetObject(ctx, l.bucket, l.key, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer o.Close()
+	return io.ReadAll(o)
+}
+
+// attributes returns the attributes of the spans of the calls to the bucket.
+func (l *lock) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("s3.bucket", l.bucket),
+		attribute.String("s3.key", l.key),
 	}
-	l.etag = o.ETag
-	return nil
 }
 
 func isNotFound(err error) bool {
+	return hasStatusCode(err, http.StatusNotFound)
+}
+
+func isPreconditionFailed(err error) bool {
+	return hasStatusCode(err, http.StatusPreconditionFailed)
+}
+
+func hasStatusCode(err error, code int) bool {
 	if err == nil {
 		return false
 	}
 	var e minio.ErrorResponse
 	if errors.As(err, &e) {
-		return e.StatusCode == 404
+		return e.StatusCode == code
 	}
 	return false
 }
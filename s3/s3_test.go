@@ -0,0 +1,170 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/leaderelectiontest"
+)
+
+func TestLockConformance(t *testing.T) {
+	srv := httptest.NewServer(newFakeS3())
+	t.Cleanup(srv.Close)
+	leaderelectiontest.RunLockConformance(t, func(t *testing.T, name, id string) le.Lock {
+		l, err := New(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "bucket", "tests", name, id, &minio.Options{
+			Creds:  credentials.NewStaticV4("access", "secret", ""),
+			Region: "us-east-1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	})
+}
+
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// fakeS3 is a minimal single bucket S3 server supporting the conditional requests
+// used by the lock. It does not check the requests signatures.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]*object
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string]*object)}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Query().Get("list-type") == "2" {
+		s.list(w, r)
+		return
+	}
+	o, ok := s.objects[r.URL.Path]
+	if m := r.Header.Get("If-Match"); m != "" && (!ok || m != `"`+o.etag+`"`) {
+		writeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", `"`+o.etag+`"`)
+		w.Header().Set("Last-Modified", o.modTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(o.data)
+		}
+	case http.MethodPut:
+		b, err := readBody(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		o := &object{data: b, etag: fmt.Sprintf("%x", md5.Sum(b)), modTime: time.Now()}
+		s.objects[r.URL.Path] = o
+		w.Header().Set("ETag", `"`+o.etag+`"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// list implements ListObjectsV2, without pagination.
+func (s *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.TrimSuffix(r.URL.Path, "/")
+	prefix := r.URL.Query().Get("prefix")
+	var keys []string
+	for k := range s.objects {
+		if k := strings.TrimPrefix(k, bucket+"/"); strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>`, strings.TrimPrefix(bucket, "/"), prefix, len(keys))
+	for _, k := range keys {
+		o := s.objects[bucket+"/"+k]
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><LastModified>%s</LastModified><ETag>"%s"</ETag><Size>%d</Size><StorageClass>STANDARD</StorageClass></Contents>`, k, o.modTime.UTC().Format(time.RFC3339), o.etag, len(o.data))
+	}
+	fmt.Fprint(w, `</ListBucketResult>`)
+}
+
+// readBody reads the request body, decoding the aws-chunked encoding used by the
+// streaming signatures.
+func readBody(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var b []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(strings.Split(line, ";")[0]), 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return b, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		b = append(b, chunk[:size]...)
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message><Resource>%s</Resource><RequestId>fake</RequestId></Error>`, code, http.StatusText(status), r.URL.Path)
+}
@@ -0,0 +1,316 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"k8s.io/utils/clock"
+)
+
+// ShardedConfig configures a ShardedElector.
+type ShardedConfig struct {
+	// Config is the template of the configuration of the shards electors.
+	// Its Lock, Priority, PriorityFunc and Callbacks are ignored.
+	Config
+
+	// Shards are the names of the shards.
+	Shards []string
+	// Count is the number of shards, named from "0" to Count-1, used when
+	// Shards is empty.
+	Count int
+
+	// NewLock returns the lock of the given shard. The locks must share the
+	// same identity, and the lock of the first shard implement
+	// CandidateRegistry: the candidates register on it only, and the other
+	// shards share its candidates.
+	NewLock func(shard string) (Lock, error)
+
+	// OnStartedLeading is called when the client starts leading the shard.
+	OnStartedLeading func(ctx context.Context, shard string)
+	// OnStoppedLeading is called when the client stops leading the shard.
+	OnStoppedLeading func(shard string)
+	// OnNewLeader, if set, is called when the client observes a new leader of the shard.
+	OnNewLeader func(shard, identity string)
+}
+
+// ShardedElector distributes the leadership of a set of shards across the
+// candidates, running a LeaderElector per shard.
+//
+// The candidates of each shard are ranked by rendezvous hashing over the
+// candidates registered on the first shard lock, and campaign with a priority
+// following their rank (see Config.Priority): the current leader transfers the
+// shard to the first ranked candidate, its owner, and the next ranked ones take
+// it over in order when the owner is gone before its registration expired. The
+// shards are rebalanced when candidates join or leave.
+type ShardedElector struct {
+	config   ShardedConfig
+	id       string
+	shards   []string
+	electors map[string]*LeaderElector
+	log      logr.Logger
+
+	mu sync.RWMutex
+	// ranks are the candidates of each shard, by decreasing rendezvous hash
+	ranks map[string][]string
+	// leading are the shards led by the client
+	leading map[string]bool
+}
+
+// NewSharded creates a ShardedElector from a ShardedConfig.
+func NewSharded(c ShardedConfig) (*ShardedElector, error) {
+	if c.NewLock == nil {
+		return nil, fmt.Errorf("NewLock must not be nil")
+	}
+	if c.OnStartedLeading == nil {
+		return nil, fmt.Errorf("OnStartedLeading callback must not be nil")
+	}
+	if c.OnStoppedLeading == nil {
+		return nil, fmt.Errorf("OnStoppedLeading callback must not be nil")
+	}
+	shards := c.Shards
+	if len(shards) == 0 {
+		for i := 0; i < c.Count; i++ {
+			shards = append(shards, strconv.Itoa(i))
+		}
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("shards must not be empty")
+	}
+	if c.Clock == nil {
+		c.Clock = clock.RealClock{}
+	}
+	s := &ShardedElector{
+		config:   c,
+		shards:   shards,
+		electors: make(map[string]*LeaderElector, len(shards)),
+		ranks:    make(map[string][]string),
+		leading:  make(map[string]bool),
+	}
+	var registry CandidateRegistry
+	for _, shard := range shards {
+		if _, ok := s.electors[shard]; ok {
+			return nil, fmt.Errorf("duplicate shard %q", shard)
+		}
+		l, err := c.NewLock(shard)
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard, err)
+		}
+		if registry == nil {
+			r, ok := l.(CandidateRegistry)
+			if !ok {
+				return nil, fmt.Errorf("shard %s: lock %v: candidates registry %w", shard, l.Describe(), ErrUnsupported)
+			}
+			registry, s.id = r, l.Identity()
+		} else if l.Identity() != s.id {
+			return nil, fmt.Errorf("shard %s: lock identity %q differs from %q", shard, l.Identity(), s.id)
+		} else {
+			l = sharedRegistry{Lock: l, registry: registry}
+		}
+		e, err := New(s.shardConfig(shard, l))
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard, err)
+		}
+		s.electors[shard] = e
+	}
+	s.log = defaultLogger(c.Logger).WithValues("name", c.Name, "identity", s.id)
+	return s, nil
+}
+
+func (s *ShardedElector) shardConfig(shard string, l Lock) Config {
+	c := s.config.Config
+	c.Lock = l
+	c.Priority = 0
+	c.PriorityFunc = func() int {
+		return s.priority(shard)
+	}
+	if c.Name != "" {
+		c.Name += "/" + shard
+	}
+	c.Callbacks = Callbacks{
+		OnStartedLeading: func(ctx context.Context) {
+			s.setLeading(shard, true)
+			s.config.OnStartedLeading(ctx, shard)
+		},
+		OnStoppedLeading: func() {
+			s.setLeading(shard, false)
+			s.config.OnStoppedLeading(shard)
+		},
+	}
+	if s.config.OnNewLeader != nil {
+		c.Callbacks.OnNewLeader = func(identity string) {
+			s.config.OnNewLeader(shard, identity)
+		}
+	}
+	return c
+}
+
+// Run campaigns for all the shards until ctx is done.
+func (s *ShardedElector) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, e := range s.electors {
+		e := e
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.Campaign(ctx, CampaignConfig{})
+		}()
+	}
+	jitterUntil(ctx, s.config.Clock, func() {
+		s.rebalance(ctx)
+	}, s.config.RetryPeriod, 0)
+	wg.Wait()
+}
+
+// Shards returns the names of the shards.
+func (s *ShardedElector) Shards() []string {
+	return append([]string(nil), s.shards...)
+}
+
+// Elector returns the LeaderElector of the given shard, or nil if there is no such shard.
+func (s *ShardedElector) Elector(shard string) *LeaderElector {
+	return s.electors[shard]
+}
+
+// Leading returns the shards led by the client.
+func (s *ShardedElector) Leading() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []string
+	for _, shard := range s.shards {
+		if s.leading[shard] {
+			out = append(out, shard)
+		}
+	}
+	return out
+}
+
+func (s *ShardedElector) setLeading(shard string, leading bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leading[shard] = leading
+}
+
+// Owner returns the candidate the shard is assigned to, which may not lead it
+// yet, or the empty string if the candidates are not known yet.
+func (s *ShardedElector) Owner(shard string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if r := s.ranks[shard]; len(r) > 0 {
+		return r[0]
+	}
+	return ""
+}
+
+// priority returns the priority of the client for the shard: the number of
+// candidates ranked after it plus one, so that the owner has the highest, or
+// zero if it is not ranked yet.
+func (s *ShardedElector) priority(shard string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := s.ranks[shard]
+	for i, id := range r {
+		if id == s.id {
+			return len(r) - i
+		}
+	}
+	return 0
+}
+
+// rebalance assigns the shards to the live candidates.
+func (s *ShardedElector) rebalance(ctx context.Context) {
+	cs, err := s.electors[s.shards[0]].Candidates(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.log.Error(err, "failed to list the shards candidates")
+		}
+		return
+	}
+	ids := make([]string, 0, len(cs))
+	for _, c := range cs {
+		ids = append(ids, c.Identity)
+	}
+	ranks := rankShards(s.shards, ids)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for shard, r := range ranks {
+		if old := s.ranks[shard]; len(r) > 0 && (len(old) == 0 || old[0] != r[0]) {
+			s.log.V(4).Info("shard assigned", "shard", shard, "owner", r[0])
+		}
+	}
+	s.ranks = ranks
+}
+
+// rankShards orders the candidates of each shard by decreasing rendezvous
+// hash, so that only the shards of a joining or leaving candidate move.
+func rankShards(shards, candidates []string) map[string][]string {
+	ranks := make(map[string][]string, len(shards))
+	for _, shard := range shards {
+		scores := make(map[string]uint64, len(candidates))
+		for _, c := range candidates {
+			h := fnv.New64a()
+			h.Write([]byte(shard))
+			h.Write([]byte{0})
+			h.Write([]byte(c))
+			scores[c] = h.Sum64()
+		}
+		r := append([]string(nil), candidates...)
+		sort.Slice(r, func(i, j int) bool {
+			if scores[r[i]] != scores[r[j]] {
+				return scores[r[i]] > scores[r[j]]
+			}
+			return r[i] < r[j]
+		})
+		ranks[shard] = r
+	}
+	return ranks
+}
+
+// sharedRegistry is the lock of a shard sharing the candidates of the first
+// shard lock, so that the candidates register once instead of once per shard.
+type sharedRegistry struct {
+	Lock
+	registry CandidateRegistry
+}
+
+// Register does nothing: the candidate registers on the first shard lock.
+func (l sharedRegistry) Register(context.Context, Candidate) error {
+	return nil
+}
+
+// Unregister does nothing: the candidate unregisters from the first shard lock.
+func (l sharedRegistry) Unregister(context.Context) error {
+	return nil
+}
+
+// Candidates returns the candidates registered on the first shard lock.
+func (l sharedRegistry) Candidates(ctx context.Context) ([]Candidate, error) {
+	return l.registry.Candidates(ctx)
+}
+
+// SetLogger sets the logger of the lock if it implements LoggerSetter.
+func (l sharedRegistry) SetLogger(log logr.Logger) {
+	if s, ok := l.Lock.(LoggerSetter); ok {
+		s.SetLogger(log)
+	}
+}
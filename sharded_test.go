@@ -0,0 +1,133 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	le "go.linka.cloud/leaderelection"
+	"go.linka.cloud/leaderelection/memory"
+)
+
+func newSharded(t *testing.T, ctx context.Context, s *memory.Store, clk *fakeClock, id string) (*le.ShardedElector, chan struct{}) {
+	e, err := le.NewSharded(le.ShardedConfig{
+		Config: le.Config{
+			LeaseDuration: leaseDuration,
+			RenewDeadline: renewDeadline,
+			RetryPeriod:   retryPeriod,
+			Clock:         clk,
+		},
+		Count: 8,
+		NewLock: func(shard string) (le.Lock, error) {
+			return memory.New(s, "shard-"+shard, id), nil
+		},
+		OnStartedLeading: func(context.Context, string) {},
+		OnStoppedLeading: func(string) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(ctx)
+	}()
+	return e, done
+}
+
+// waitShards steps the fake clock until the electors lead the given number of
+// shards each, failing after max.
+func waitShards(t *testing.T, clk *fakeClock, max time.Duration, want map[*le.ShardedElector]int) {
+	t.Helper()
+	start := clk.Now()
+	for {
+		ok := true
+		for e, n := range want {
+			if len(e.Leading()) != n {
+				ok = false
+			}
+		}
+		if ok {
+			return
+		}
+		if clk.Since(start) > max {
+			for e, n := range want {
+				t.Errorf("got shards %v, want %d", e.Leading(), n)
+			}
+			t.FailNow()
+		}
+		step(clk, retryPeriod/4)
+	}
+}
+
+func TestSharded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := memory.NewStore()
+	clk := newFakeClock()
+	a, _ := newSharded(t, ctx, s, clk, "a")
+	waitShards(t, clk, 2*retryPeriod, map[*le.ShardedElector]int{a: 8})
+
+	bctx, bcancel := context.WithCancel(ctx)
+	b, bdone := newSharded(t, bctx, s, clk, "b")
+	var owned int
+	for i := 0; i < 2*int(leaseDuration/retryPeriod); i++ {
+		step(clk, retryPeriod)
+	}
+	for _, shard := range a.Shards() {
+		if o := b.Owner(shard); o == "b" {
+			owned++
+		} else if o != "a" {
+			t.Fatalf("shard %s assigned to %q", shard, o)
+		}
+		if a.Owner(shard) != b.Owner(shard) {
+			t.Errorf("shard %s assigned to %q and %q", shard, a.Owner(shard), b.Owner(shard))
+		}
+	}
+	if owned == 0 || owned == 8 {
+		t.Fatalf("got %d shards assigned to b", owned)
+	}
+	waitShards(t, clk, 2*leaseDuration, map[*le.ShardedElector]int{a: 8 - owned, b: owned})
+	shards := append(a.Leading(), b.Leading()...)
+	sort.Strings(shards)
+	for i, shard := range shards {
+		if i > 0 && shards[i-1] == shard {
+			t.Errorf("shard %s led twice", shard)
+		}
+		// the owner is ranked first of the two candidates
+		if r, _ := s.Record("shard-" + shard); r.HolderPriority != 2 {
+			t.Errorf("shard %s led with priority %d, want 2", shard, r.HolderPriority)
+		}
+	}
+	for _, shard := range a.Shards() {
+		cs, err := memory.New(s, "shard-"+shard, "c").Candidates(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[bool]int{true: 2, false: 0}[shard == "0"]; len(cs) != want {
+			t.Errorf("got %d candidates registered on shard %s, want %d", len(cs), shard, want)
+		}
+		if cs, _ := b.Elector(shard).Candidates(ctx); len(cs) != 2 {
+			t.Errorf("got %d candidates of shard %s, want 2", len(cs), shard)
+		}
+	}
+
+	bcancel()
+	<-bdone
+	waitShards(t, clk, 3*leaseDuration, map[*le.ShardedElector]int{a: 8})
+}
@@ -22,14 +22,14 @@ import (
 	"os"
 	"os/signal"
 
-	"github.com/sirupsen/logrus"
+	"k8s.io/klog/v2"
 )
 
 var onlyOneSignalHandler = make(chan struct{})
 
 // SetupSignalHandler registers for SIGTERM and SIGINT. A context is returned
 // which is canceled on one of these signals. If a second signal is caught, the program
-// is terminated with exit code 1.
+// is terminated with exit code 1. The signals are logged with the klog logger.
 func SetupSignalHandler() context.Context {
 	close(onlyOneSignalHandler) // panics when called twice
 
@@ -37,14 +37,15 @@ func SetupSignalHandler() context.Context {
 
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, shutdownSignals...)
+	log := klog.Background()
 	go func() {
 		<-c
 		fmt.Println()
-		logrus.Infof("received first signal, gracefully shutting down")
+		log.Info("received first signal, gracefully shutting down")
 		cancel()
 		<-c
 		fmt.Println()
-		logrus.Warnf("received second signal, terminating")
+		log.Info("received second signal, terminating")
 		os.Exit(1) // second signal. Exit directly.
 	}()
 
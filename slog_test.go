@@ -0,0 +1,35 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.21
+
+package leaderelection_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	le "go.linka.cloud/leaderelection"
+)
+
+func TestFromSlog(t *testing.T) {
+	var buf bytes.Buffer
+	l := le.FromSlog(slog.New(slog.NewTextHandler(&buf, nil)))
+	l.WithValues("identity", "a").Info("successfully acquired lease")
+	if got := buf.String(); !strings.Contains(got, `msg="successfully acquired lease" identity=a`) {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import "time"
+
+// Status is a snapshot of the state of a LeaderElector.
+type Status struct {
+	// Name is the name of the lease, Identity the identity of the client and
+	// Lock the description of the lock.
+	Name     string
+	Identity string
+	Lock     string
+	// Leader is the last observed leader, and Leading whether the client
+	// holds a leadership term, which ends as soon as it transfers, resigns
+	// or fails to renew the lease, even if the record still names it.
+	Leader  string
+	Leading bool
+	// Paused is true while campaigning is paused, see Pause.
+	Paused bool
+	// Record is the last observed record, ObservedTime the time it was
+	// observed, both zero if no record was observed yet, and ExpiresIn the
+	// time left before the lease expires, if held.
+	Record       Record
+	ObservedTime time.Time
+	ExpiresIn    time.Duration
+	// LastError is the error of the last attempt to acquire or renew the
+	// lease, nil if it succeeded or the lease was held by another client.
+	LastError error
+}
+
+// Status returns the current state of the client. It does not wait for the
+// lock operations in flight.
+func (le *LeaderElector) Status() Status {
+	r, _ := le.observed.get()
+	s := Status{
+		Name:         le.config.Name,
+		Identity:     le.config.Lock.Identity(),
+		Lock:         le.config.Lock.Describe(),
+		Leader:       r.HolderIdentity,
+		Paused:       le.paused.Load(),
+		Record:       r,
+		ObservedTime: le.observed.at(),
+	}
+	if s.Leader != "" {
+		expiry := s.ObservedTime.Add(time.Duration(r.LeaseDurationMilliSeconds) * time.Millisecond)
+		if d := expiry.Sub(le.clock.Now()); d > 0 {
+			s.ExpiresIn = d
+		}
+	}
+	s.Leading = le.active.Load() && s.Leader == s.Identity && r.TransferTo == ""
+	if err := le.lastError.Load(); err != nil {
+		s.LastError = *err
+	}
+	return s
+}
+
+// Pause stops the client from campaigning: it keeps observing the record, but
+// no longer tries to acquire the lease until Resume is called. A leader keeps
+// its current term, and does not acquire the lease again once it ended, e.g.
+// after Resign.
+func (le *LeaderElector) Pause() {
+	if !le.paused.Swap(true) {
+		le.log.Info("campaigning paused")
+	}
+}
+
+// Resume makes the client campaign again after Pause.
+func (le *LeaderElector) Resume() {
+	if le.paused.Swap(false) {
+		le.log.Info("campaigning resumed")
+	}
+}
+
+// Paused returns whether campaigning is paused.
+func (le *LeaderElector) Paused() bool {
+	return le.paused.Load()
+}
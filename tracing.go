@@ -0,0 +1,101 @@
+// Copyright 2023 Linka Cloud  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer of the LeaderElector spans.
+const instrumentationName = "go.linka.cloud/leaderelection"
+
+const (
+	attrName      = attribute.Key("leaderelection.name")
+	attrIdentity  = attribute.Key("leaderelection.identity")
+	attrLock      = attribute.Key("leaderelection.lock")
+	attrPhase     = attribute.Key("leaderelection.phase")
+	attrOutcome   = attribute.Key("leaderelection.outcome")
+	attrConflict  = attribute.Key("leaderelection.conflict")
+	attrSucceeded = attribute.Key("leaderelection.succeeded")
+)
+
+// StartSpan starts a span named name as a child of the span carried by ctx,
+// using the TracerProvider of that span. It is meant for the Lock
+// implementations to trace their own calls within the spans of the
+// LeaderElector, and does nothing when ctx carries no span.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends the span, recording err as its outcome.
+func EndSpan(span trace.Span, err error) {
+	outcome := "success"
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrConflict):
+		outcome = "conflict"
+	case errors.Is(err, os.ErrNotExist):
+		outcome = "not_found"
+	default:
+		outcome = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attrOutcome.String(outcome), attrConflict.Bool(errors.Is(err, ErrConflict)))
+	span.End()
+}
+
+// phase returns the phase of the current attempt to acquire or renew the lease.
+// It must be called with mu held.
+func (le *LeaderElector) phase() string {
+	if le.leading {
+		return "renew"
+	}
+	return "acquire"
+}
+
+// attributes returns the attributes of the spans of the given phase.
+func (le *LeaderElector) attributes(phase string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attrName.String(le.config.Name),
+		attrIdentity.String(le.config.Lock.Identity()),
+		attrLock.String(le.config.Lock.Describe()),
+		attrPhase.String(phase),
+	}
+}
+
+// operation starts the Lock operation ("get", "create" or "update") of the
+// given phase. It returns the context to pass to the Lock, carrying the span
+// and the logger of the client, and the function to call with the error it
+// returned, which ends its span, records its latency and whether the lock answered.
+func (le *LeaderElector) operation(ctx context.Context, phase, operation string) (context.Context, func(err error)) {
+	start := le.clock.Now()
+	ctx = logr.NewContext(ctx, le.log)
+	ctx, span := le.tracer.Start(ctx, "leaderelection."+operation, trace.WithAttributes(le.attributes(phase)...))
+	return ctx, func(err error) {
+		if err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrConflict) {
+			le.reached.Store(le.clock.Now().UnixNano())
+		}
+		EndSpan(span, err)
+		le.metrics.latency(le.config.Name, phase, operation, le.clock.Since(start))
+	}
+}